@@ -5,11 +5,15 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/fatih/color"
 )
 
 const (
@@ -34,6 +38,20 @@ var (
 	Rand            *rand.Rand
 	ShowStatus      bool
 	UpdateIPByIndex int
+	Daemon          bool
+	Monitor         bool
+	RemoveHosts     bool
+	Rollback        bool
+	DryRun          bool
+	HistoryIP       string
+	HistoryBest     bool
+	HistoryColo     string
+	HistoryDays     int
+	HistoryTop      int
+	Report          bool
+	PrintFormat     string                // table, csv, json, ndjson or markdown (-report only)
+	ResultOutput    io.Writer = os.Stdout // 非 table 格式的结果输出位置
+	InfoOutput      io.Writer = os.Stdout // 提示信息的输出位置，非 table 格式时为 stderr
 )
 
 type StrSet map[string]struct{}
@@ -64,20 +82,173 @@ func (s *StrSet) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// StageConfig 多阶段筛选中的一个阶段，每个阶段只测试上一阶段留下的 IP
+type StageConfig struct {
+	Mode        string        `json:"Mode"`        // tcp, tls, http, trace or download
+	Limit       int           `json:"Limit"`       // top N passed to next stage, 0 means all passed
+	MaxDelay    time.Duration `json:"MaxDelay"`    // 0 means no limit
+	MaxLossRate float32       `json:"MaxLossRate"` // 0 means no limit
+	MinSpeed    float64       `json:"MinSpeed"`    // MB/s, 0 means no limit
+	Timeout     time.Duration `json:"Timeout"`     // stage time budget, 0 means no limit
+	StopCount   int           `json:"StopCount"`   // stop the stage once N IPs pass, 0 means test all
+}
+
+// HostGroupConfig 一组域名单独指定地区码、选择策略和地址族
+type HostGroupConfig struct {
+	Name     string   `json:"Name"`
+	Hosts    []string `json:"Hosts"`
+	Colos    StrSet   `json:"Colos"`    // empty means any colo
+	Strategy string   `json:"Strategy"` // best, round-robin or hash (by host name, "random" is an old alias)
+	TopN     int      `json:"TopN"`     // candidates for round-robin and hash, 0 means all
+	Family   string   `json:"Family"`   // ipv4, ipv6, dual or any
+}
+
+// DNSOutputConfig 把 hosts 记录写成 DNS 服务器的配置文件
+type DNSOutputConfig struct {
+	Format        string `json:"Format"` // dnsmasq, unbound or coredns
+	Path          string `json:"Path"`
+	ReloadCommand string `json:"ReloadCommand"` // run after the file changed, empty means none
+	TTL           int    `json:"TTL"`           // unbound local-data TTL, 0 means default
+}
+
+// CloudflareRecordConfig 通过 Cloudflare API 发布的一条记录
+type CloudflareRecordConfig struct {
+	Name    string `json:"Name"`
+	Type    string `json:"Type"`  // A or AAAA
+	Count   int    `json:"Count"` // publish the top N IPs, 0 means 1
+	TTL     int    `json:"TTL"`   // 0 or 1 means automatic, proxied records are always automatic
+	Proxied bool   `json:"Proxied"`
+}
+
+// RFC2136RecordConfig 通过 RFC 2136 UPDATE 发布的一条记录
+type RFC2136RecordConfig struct {
+	Server       string `json:"Server"` // primary server, port defaults to 53
+	Zone         string `json:"Zone"`
+	Name         string `json:"Name"`
+	Type         string `json:"Type"`         // A or AAAA
+	Count        int    `json:"Count"`        // publish the top N IPs, 0 means 1
+	TTL          uint32 `json:"TTL"`          // 0 means 60
+	KeyName      string `json:"KeyName"`      // empty sends unsigned updates
+	KeyAlgorithm string `json:"KeyAlgorithm"` // hmac-sha256 (default), hmac-sha512, hmac-sha1 or hmac-md5
+	KeySecret    string `json:"KeySecret"`    // base64
+}
+
+// ProxyExportConfig 根据模板生成代理客户端配置
+type ProxyExportConfig struct {
+	Format   string `json:"Format"` // clash, sing-box or xray
+	Template string `json:"Template"`
+	Output   string `json:"Output"`
+	Node     string `json:"Node"`   // name or tag of the prototype node, empty means the first node with a server
+	Count    int    `json:"Count"`  // one node for each of the top N IPs, 0 means 5
+	Port     int    `json:"Port"`   // 0 keeps the template port
+	Family   string `json:"Family"` // ipv4, ipv6 or any (default)
+}
+
+// UpstreamExportConfig 生成 nginx upstream 或 HAProxy backend
+type UpstreamExportConfig struct {
+	Format        string `json:"Format"` // nginx or haproxy
+	Output        string `json:"Output"`
+	Name          string `json:"Name"`  // upstream or backend name, empty means cloudflare
+	Count         int    `json:"Count"` // top N IPs, 0 means 5
+	Port          int    `json:"Port"`  // 0 means 443
+	Family        string `json:"Family"`
+	ServerOptions string `json:"ServerOptions"` // appended to each server line
+	ReloadCommand string `json:"ReloadCommand"` // run after the file changed, empty means none
+}
+
+// TemplateExportConfig 用 text/template 模板生成文件
+type TemplateExportConfig struct {
+	Template string `json:"Template"`
+	Output   string `json:"Output"`
+}
+
 type ConfigJson struct {
 	// base config
 	OutputFile         string   `json:"OutputFile"`
-	TestMode           string   `json:"TestMode"`
+	TestMode           string   `json:"TestMode"` // tcp, tls, http or trace, ignored when Stages is set
 	EnableDownLoadTest bool     `json:"EnableDownLoadTest"`
 	FastTest           bool     `json:"FastTest"`
 	WebHosts           []string `json:"WebHosts"`
 	TestIPNum          int      `json:"TestIPNum"` // set -1 if test all IPs
 	SaveIPNum          int      `json:"SaveIPNum"`
+	OutputFormats      []string `json:"OutputFormats"`    // extra formats saved next to OutputFile: json, ndjson
+	HistoryFile        string   `json:"HistoryFile"`      // append every measurement, empty disables history
+	RunsFile           string   `json:"RunsFile"`         // append a summary of every run for -report, empty disables it
+	HostsRemoveStale   bool     `json:"HostsRemoveStale"` // remove hosts no longer in WebHosts or HostGroups from the managed block
+	// records older than HistoryMaxAge are removed from HistoryFile after each run
+	HistoryMaxAge time.Duration `json:"HistoryMaxAge"` // 0 keeps all
+	// merge measurements with HistoryFile (not merged when it is empty), weights halve every MergeHalfLife
+	MergeHalfLife      time.Duration `json:"MergeHalfLife"`      // 0 disables merging
+	MergeMaxAge        time.Duration `json:"MergeMaxAge"`        // older measurements are dropped, 0 keeps all
+	MergeMinConfidence float64       `json:"MergeMinConfidence"` // minimum confidence to reuse a historical speed
+	// rank by how each IP performed at the same hours of day in HistoryFile, daemon mode uses the time until the next run
+	TimeOfDayWindow     time.Duration `json:"TimeOfDayWindow"`     // 0 disables time-of-day ranking
+	TimeOfDayDays       int           `json:"TimeOfDayDays"`       // days of history used for the profiles
+	TimeOfDayMinSamples int           `json:"TimeOfDayMinSamples"` // IPs with fewer successes in the window keep the current values
+	// host groups, each group picks its own IPs, WebHosts always gets the best IP
+	HostGroups []HostGroupConfig `json:"HostGroups"`
+	// DNS server config outputs, written together with hosts unless DisableHosts is set
+	DNSOutputs   []DNSOutputConfig `json:"DNSOutputs"`
+	DisableHosts bool              `json:"DisableHosts"`
+	// built-in DNS server for daemon and monitor mode, empty DNSServerAddr disables it
+	DNSServerAddr     string `json:"DNSServerAddr"`
+	DNSServerUpstream string `json:"DNSServerUpstream"` // empty refuses other names
+	DNSServerTTL      uint32 `json:"DNSServerTTL"`
+	// Cloudflare DNS API publisher, empty CloudflareZoneID disables it
+	CloudflareAPIURL   string                   `json:"CloudflareAPIURL"`
+	CloudflareAPIToken string                   `json:"CloudflareAPIToken"`
+	CloudflareZoneID   string                   `json:"CloudflareZoneID"`
+	CloudflareRecords  []CloudflareRecordConfig `json:"CloudflareRecords"`
+	// RFC 2136 dynamic update publisher
+	RFC2136Records []RFC2136RecordConfig `json:"RFC2136Records"`
+	// proxy client config exports
+	ProxyExports []ProxyExportConfig `json:"ProxyExports"`
+	// nginx / HAProxy upstream exports
+	UpstreamExports []UpstreamExportConfig `json:"UpstreamExports"`
+	// text/template exports, see exporter.TemplateData for the template data
+	TemplateExports []TemplateExportConfig `json:"TemplateExports"`
+	// hosts file config, empty HostsFile means the system hosts file,
+	// back up hosts file before each change, 0 HostsBackupNum keeps all backups
+	HostsFile      string `json:"HostsFile"`
+	HostsBackupDir string `json:"HostsBackupDir"`
+	HostsBackupNum int    `json:"HostsBackupNum"`
+	// stage config, run stages in order instead of TestMode + download test
+	Stages []StageConfig `json:"Stages"`
+	// time budget config, 0 means no limit
+	RunTimeout           time.Duration `json:"RunTimeout"`
+	LatencyPhaseTimeout  time.Duration `json:"LatencyPhaseTimeout"`
+	DownloadPhaseTimeout time.Duration `json:"DownloadPhaseTimeout"`
+	// early stop config, stop once N IPs meet the thresholds, 0 means test all
+	LatencyStopCount       int           `json:"LatencyStopCount"`
+	LatencyStopMaxDelay    time.Duration `json:"LatencyStopMaxDelay"`
+	LatencyStopMaxLossRate float32       `json:"LatencyStopMaxLossRate"`
+	DownloadStopCount      int           `json:"DownloadStopCount"`
+	DownloadStopMinSpeed   float64       `json:"DownloadStopMinSpeed"` // MB/s
+	// daemon config, DaemonCron takes precedence over DaemonInterval
+	DaemonInterval time.Duration `json:"DaemonInterval"`
+	DaemonCron     string        `json:"DaemonCron"` // e.g. "0 */6 * * *"
+	// switch config, keep the current IP unless the best one is better by SwitchMargin
+	// or the current one exceeds SwitchMaxDelay / SwitchMaxLossRate or is slower than SwitchMinSpeed, 0 margin always switches
+	SwitchMargin      float64       `json:"SwitchMargin"`
+	SwitchMaxDelay    time.Duration `json:"SwitchMaxDelay"`
+	SwitchMaxLossRate float32       `json:"SwitchMaxLossRate"`
+	SwitchMinSpeed    float64       `json:"SwitchMinSpeed"` // MB/s, only checked when EnableDownLoadTest is set
+	// monitor config, probe the IP in hosts file with TestMode, 0 interval disables it
+	MonitorInterval    time.Duration `json:"MonitorInterval"`
+	MonitorMaxDelay    time.Duration `json:"MonitorMaxDelay"`
+	MonitorMaxLossRate float32       `json:"MonitorMaxLossRate"`
+	MonitorEventFile   string        `json:"MonitorEventFile"`
 	// ip config
 	CIDRIPV4File    string `json:"CIDRIPV4File"` // https://www.cloudflare.com/ips-v4
 	CIDRIPV6File    string `json:"CIDRIPV6File"` // https://www.cloudflare.com/ips-v6
 	AllowIPV4RBFile string `json:"AllowIPV4RBFile"`
 	DenyIPV4RBFile  string `json:"DenyIPV4RBFile"`
+	// scan rate config
+	ProbeRateLimit      int     `json:"ProbeRateLimit"` // probes (connections or requests) per second, 0 means no limit
+	AdaptiveConcurrency bool    `json:"AdaptiveConcurrency"`
+	AdaptiveMinRoutines int     `json:"AdaptiveMinRoutines"`
+	AdaptiveHighRatio   float64 `json:"AdaptiveHighRatio"` // timeout ratio to halve routines
+	AdaptiveLowRatio    float64 `json:"AdaptiveLowRatio"`  // timeout ratio to add routines
 	// tcp config
 	TcpRoutines       int           `json:"TcpRoutines"`
 	TcpPort           int           `json:"TcpPort"`
@@ -92,6 +263,10 @@ type ConfigJson struct {
 	HttpStatusCode     int           `json:"HttpStatusCode"`
 	HttpURL            string        `json:"HttpURL"`
 	HttpTCPPort        int           `json:"HttpTCPPort"`
+	// tls config, uses tcp routines, port, times and timeout
+	TLSServerName string `json:"TLSServerName"`
+	// trace config, uses http routines, colo, times, timeout and port
+	TraceURL string `json:"TraceURL"`
 	// download config
 	DownloadTestIPNum   int           `json:"DownloadTestIPNum"`
 	DownloadIPTestTimes int           `json:"DownloadIPTestTimes"`
@@ -109,10 +284,33 @@ func NewConfigJson() *ConfigJson {
 		WebHosts:            []string{},
 		TestIPNum:           100,
 		SaveIPNum:           100,
+		HistoryFile:         "history.ndjson",
+		HistoryMaxAge:       30 * 24 * time.Hour,
+		RunsFile:            "runs.ndjson",
+		MergeHalfLife:       24 * time.Hour,
+		MergeMaxAge:         7 * 24 * time.Hour,
+		MergeMinConfidence:  0.3,
+		TimeOfDayDays:       14,
+		TimeOfDayMinSamples: 3,
+		HostsBackupDir:      "hosts_backup",
+		HostsBackupNum:      10,
 		CIDRIPV4File:        "ip.txt",
 		CIDRIPV6File:        "ipv6.txt",
 		AllowIPV4RBFile:     "allow_ipv4.rb",
 		DenyIPV4RBFile:      "deny_ipv4.rb",
+		DNSServerUpstream:   "1.1.1.1:53",
+		DNSServerTTL:        60,
+		CloudflareAPIURL:    "https://api.cloudflare.com/client/v4",
+		DaemonInterval:      6 * time.Hour,
+		SwitchMargin:        0.1,
+		SwitchMaxDelay:      MaxAllowDelay,
+		SwitchMaxLossRate:   0.5,
+		MonitorMaxDelay:     MaxAllowDelay,
+		MonitorMaxLossRate:  0.5,
+		MonitorEventFile:    "monitor_events.csv",
+		AdaptiveMinRoutines: 4,
+		AdaptiveHighRatio:   0.5,
+		AdaptiveLowRatio:    0.1,
 		TcpRoutines:         30,
 		TcpPort:             443,
 		TcpConnectTimes:     3,
@@ -123,6 +321,9 @@ func NewConfigJson() *ConfigJson {
 		HttpConnectTimeout:  5 * time.Second,
 		HttpRoutines:        10,
 		HttpStatusCode:      200,
+		HttpTCPPort:         443,
+		TLSServerName:       "cloudflare.com",
+		TraceURL:            "https://cloudflare.com/cdn-cgi/trace",
 		DownloadTestIPNum:   10,
 		DownloadIPTestTimes: 1,
 		DownloadTimeout:     3 * time.Second,
@@ -226,13 +427,75 @@ func doInit() error {
 	var testIPNum = flag.Int("n", -1, "number of IPs to test")
 	var showStatus = flag.Bool("s", false, "show status")
 	var updateIPByIndex = flag.Int("u", -1, "update IP by result with index")
+	var runTimeout = flag.Duration("timeout", 0, "total run deadline, e.g. 10m")
+	var daemon = flag.Bool("daemon", false, "repeat speed test on DaemonInterval or DaemonCron")
+	var removeHosts = flag.Bool("hosts-remove", false, "remove the CloudflareSpeedTest block from hosts file")
+	var rollback = flag.Bool("rollback", false, "restore hosts file from the latest backup")
+	var dryRun = flag.Bool("dry-run", false, "print the hosts file diff instead of writing it")
+	var monitor = flag.Bool("monitor", false, "only monitor the IP in hosts file and fail over to last results")
+	var historyIP = flag.String("history-ip", "", "show the history of an IP")
+	var historyBest = flag.Bool("history-best", false, "show the best IPs of the last -history-days days")
+	var historyColo = flag.String("history-colo", "", "show the daily trend of a colo over the last -history-days days")
+	var historyDays = flag.Int("history-days", 7, "time window of -history-best and -history-colo")
+	var historyTop = flag.Int("history-top", 10, "number of IPs shown by -history-best and per colo by -report")
+	var report = flag.Bool("report", false, "report colos, CIDRs and allow/deny growth of the last -history-days days")
+	var printFormat = flag.String("format", "table", "printed result format: table, csv, json or ndjson; history queries support table, json or ndjson, -report table, json or markdown")
+	var outputFormats = flag.String("output-format", "", "extra result file formats besides csv, e.g. json,ndjson")
 	flag.Parse()
-	fmt.Println("config:", *configFilePath)
+	PrintFormat = *printFormat
+	if PrintFormat != "table" {
+		// stdout 只输出结果，提示信息（包括彩色输出）改为输出到 stderr，便于通过管道交给 jq
+		InfoOutput = os.Stderr
+		color.Output = os.Stderr
+	}
+	fmt.Fprintln(InfoOutput, "config:", *configFilePath)
 	err = loadConfigJson(*configFilePath)
 	if *testIPNum != -1 {
 		Config.TestIPNum = *testIPNum
 	}
+	if *runTimeout > 0 {
+		Config.RunTimeout = *runTimeout
+	}
+	if *outputFormats != "" {
+		Config.OutputFormats = strings.Split(*outputFormats, ",")
+	}
 	ShowStatus = *showStatus
 	UpdateIPByIndex = *updateIPByIndex
+	Daemon = *daemon
+	Monitor = *monitor
+	RemoveHosts = *removeHosts
+	Rollback = *rollback
+	DryRun = *dryRun
+	HistoryIP = *historyIP
+	HistoryBest = *historyBest
+	HistoryColo = *historyColo
+	HistoryDays = *historyDays
+	HistoryTop = *historyTop
+	Report = *report
+	formatErr := checkPrintFormat()
+	if formatErr != nil {
+		return formatErr
+	}
 	return err
 }
+
+// 检查当前命令是否支持 -format，避免测速结束后才发现无法输出
+func checkPrintFormat() error {
+	var formats []string
+	switch {
+	case ShowStatus:
+		formats = []string{"table"}
+	case HistoryIP != "" || HistoryBest || HistoryColo != "":
+		formats = []string{"table", "json", "ndjson"}
+	case Report:
+		formats = []string{"table", "json", "markdown"}
+	default:
+		formats = []string{"table", "csv", "json", "ndjson"}
+	}
+	for _, format := range formats {
+		if PrintFormat == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("当前命令不支持 -format %s，可选 %s", PrintFormat, strings.Join(formats, " / "))
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// ExchangeRaw 向 addr 发送原始报文并返回原始响应，network 为 udp 或 tcp；
+// ctx 没有截止时间时默认 5 秒超时
+func ExchangeRaw(ctx context.Context, network, addr string, req []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if network == "tcp" {
+		return exchangeTCP(conn, req)
+	}
+	_, err = conn.Write(req)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 丢弃 ID 不匹配的响应
+		if n >= 2 && len(req) >= 2 && buf[0] == req[0] && buf[1] == req[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+func exchangeTCP(conn net.Conn, req []byte) ([]byte, error) {
+	err := writeTCPMessage(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+// TCP 报文前有两字节的长度
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > 0xFFFF {
+		return fmt.Errorf("DNS 报文过长")
+	}
+	_, err := w.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	_, err := io.ReadFull(r, length[:])
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Exchange 发送报文并解码响应，UDP 响应被截断时改用 TCP 重试
+func Exchange(ctx context.Context, network, addr string, m *Message) (*Message, error) {
+	req, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ExchangeRaw(ctx, network, addr, req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Unpack(resp)
+	if err != nil {
+		return nil, err
+	}
+	if r.ID != m.ID {
+		return nil, fmt.Errorf("DNS 响应 ID 不匹配")
+	}
+	if network == "udp" && r.Flags&FlagTC != 0 {
+		return Exchange(ctx, "tcp", addr, m)
+	}
+	return r, nil
+}
@@ -0,0 +1,266 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 记录类型和类别，只列出用到的部分
+const (
+	TypeA    uint16 = 1
+	TypeNS   uint16 = 2
+	TypeSOA  uint16 = 6
+	TypeAAAA uint16 = 28
+	TypeOPT  uint16 = 41
+	TypeTSIG uint16 = 250
+	TypeANY  uint16 = 255
+
+	ClassINET uint16 = 1
+	ClassNONE uint16 = 254
+	ClassANY  uint16 = 255
+)
+
+// 头部标志位
+const (
+	FlagQR uint16 = 1 << 15
+	FlagAA uint16 = 1 << 10
+	FlagTC uint16 = 1 << 9
+	FlagRD uint16 = 1 << 8
+	FlagRA uint16 = 1 << 7
+
+	OpcodeQuery  uint16 = 0
+	OpcodeUpdate uint16 = 5
+
+	RcodeSuccess  uint16 = 0
+	RcodeFormErr  uint16 = 1
+	RcodeServFail uint16 = 2
+	RcodeNXDomain uint16 = 3
+	RcodeNotImp   uint16 = 4
+	RcodeRefused  uint16 = 5
+	RcodeNotAuth  uint16 = 9
+)
+
+var errShortMessage = errors.New("DNS 报文长度不足")
+
+// Question 问题段
+type Question struct {
+	Name  string // 不带结尾的点，保留原始大小写
+	Type  uint16
+	Class uint16
+}
+
+// RR 资源记录，Data 为未解析的 RDATA
+type RR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// Message DNS 报文，UPDATE 报文中 Question/Answer/Authority 分别对应 Zone/Prerequisite/Update 段
+type Message struct {
+	ID         uint16
+	Flags      uint16
+	Question   []Question
+	Answer     []RR
+	Authority  []RR
+	Additional []RR
+}
+
+func (m *Message) Opcode() uint16 { return m.Flags >> 11 & 0xF }
+func (m *Message) Rcode() uint16  { return m.Flags & 0xF }
+
+// SetOpcode 设置操作码
+func (m *Message) SetOpcode(opcode uint16) { m.Flags = m.Flags&^(0xF<<11) | opcode<<11 }
+
+// SetRcode 设置响应码
+func (m *Message) SetRcode(rcode uint16) { m.Flags = m.Flags&^0xF | rcode }
+
+// Reply 生成对 m 的响应报文，复制 ID、操作码、RD 标志和问题段
+func (m *Message) Reply(rcode uint16) *Message {
+	r := &Message{ID: m.ID, Question: m.Question}
+	r.Flags = FlagQR | m.Flags&FlagRD
+	r.SetOpcode(m.Opcode())
+	r.SetRcode(rcode)
+	return r
+}
+
+// Pack 编码报文，域名不压缩
+func (m *Message) Pack() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.Flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Question)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answer)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additional)))
+	var err error
+	for _, q := range m.Question {
+		b, err = AppendName(b, q.Name)
+		if err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, q.Type)
+		b = appendUint16(b, q.Class)
+	}
+	for _, section := range [][]RR{m.Answer, m.Authority, m.Additional} {
+		for _, rr := range section {
+			b, err = rr.append(b)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func (rr *RR) append(b []byte) ([]byte, error) {
+	b, err := AppendName(b, rr.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rr.Data) > 0xFFFF {
+		return nil, fmt.Errorf("记录 %s 的数据过长", rr.Name)
+	}
+	b = appendUint16(b, rr.Type)
+	b = appendUint16(b, rr.Class)
+	b = appendUint32(b, rr.TTL)
+	b = appendUint16(b, uint16(len(rr.Data)))
+	return append(b, rr.Data...), nil
+}
+
+// AppendName 以不压缩的格式追加域名，"" 或 "." 表示根域
+func AppendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("无效的域名 [%s]", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	if len(name) > 253 {
+		return nil, fmt.Errorf("域名过长 [%s]", name)
+	}
+	return append(b, 0), nil
+}
+
+// Unpack 解码报文
+func Unpack(b []byte) (*Message, error) {
+	if len(b) < 12 {
+		return nil, errShortMessage
+	}
+	m := &Message{
+		ID:    binary.BigEndian.Uint16(b[0:]),
+		Flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	qdCount := int(binary.BigEndian.Uint16(b[4:]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(b[6:])),
+		int(binary.BigEndian.Uint16(b[8:])),
+		int(binary.BigEndian.Uint16(b[10:])),
+	}
+	off := 12
+	for i := 0; i < qdCount; i++ {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(b) {
+			return nil, errShortMessage
+		}
+		m.Question = append(m.Question, Question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[off:]),
+			Class: binary.BigEndian.Uint16(b[off+2:]),
+		})
+		off += 4
+	}
+	sections := []*[]RR{&m.Answer, &m.Authority, &m.Additional}
+	for i, section := range sections {
+		for j := 0; j < counts[i]; j++ {
+			rr, n, err := readRR(b, off)
+			if err != nil {
+				return nil, err
+			}
+			off = n
+			*section = append(*section, rr)
+		}
+	}
+	return m, nil
+}
+
+func readRR(b []byte, off int) (RR, int, error) {
+	name, off, err := readName(b, off)
+	if err != nil {
+		return RR{}, 0, err
+	}
+	if off+10 > len(b) {
+		return RR{}, 0, errShortMessage
+	}
+	rr := RR{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(b[off:]),
+		Class: binary.BigEndian.Uint16(b[off+2:]),
+		TTL:   binary.BigEndian.Uint32(b[off+4:]),
+	}
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+length > len(b) {
+		return RR{}, 0, errShortMessage
+	}
+	rr.Data = append([]byte(nil), b[off:off+length]...)
+	return rr, off + length, nil
+}
+
+// 读取域名（支持压缩指针），返回不带结尾点的域名和域名之后的偏移
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errShortMessage
+		}
+		length := int(b[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case length&0xC0 == 0xC0:
+			if off+2 > len(b) {
+				return "", 0, errShortMessage
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("DNS 报文中的域名压缩指针过多")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+		case length > 63:
+			return "", 0, errors.New("DNS 报文中的域名标签无效")
+		default:
+			if off+1+length > len(b) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, string(b[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
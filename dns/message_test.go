@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackUnpackRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *Message
+	}{
+		{
+			name: "查询",
+			m: &Message{ID: 0x1234, Flags: FlagRD, Question: []Question{
+				{Name: "www.Example.com", Type: TypeA, Class: ClassINET},
+			}},
+		},
+		{
+			name: "A 和 AAAA 应答",
+			m: &Message{ID: 1, Flags: FlagQR | FlagAA | FlagRD | FlagRA,
+				Question: []Question{{Name: "a.test", Type: TypeANY, Class: ClassINET}},
+				Answer: []RR{
+					{Name: "a.test", Type: TypeA, Class: ClassINET, TTL: 60, Data: []byte{1, 1, 1, 1}},
+					{Name: "a.test", Type: TypeAAAA, Class: ClassINET, TTL: 60, Data: make([]byte, 16)},
+				},
+			},
+		},
+		{
+			name: "UPDATE 报文的各段",
+			m: func() *Message {
+				m := &Message{ID: 7,
+					Question:   []Question{{Name: "zone.test", Type: TypeSOA, Class: ClassINET}},
+					Authority:  []RR{{Name: "a.zone.test", Type: TypeA, Class: ClassANY}},
+					Additional: []RR{{Name: "", Type: TypeOPT, Class: 1232}},
+				}
+				m.SetOpcode(OpcodeUpdate)
+				return m
+			}(),
+		},
+		{
+			name: "错误码",
+			m: func() *Message {
+				m := &Message{ID: 9, Flags: FlagQR, Question: []Question{{Name: "x.test", Type: TypeA, Class: ClassINET}}}
+				m.SetRcode(RcodeNXDomain)
+				return m
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.m.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Unpack(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.m) {
+				t.Errorf("got %+v\nwant %+v", got, tt.m)
+			}
+			if got.Opcode() != tt.m.Opcode() || got.Rcode() != tt.m.Rcode() {
+				t.Errorf("opcode/rcode 不一致")
+			}
+		})
+	}
+}
+
+func TestUnpackCompression(t *testing.T) {
+	b := []byte{0, 1, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 0}
+	b, _ = AppendName(b, "www.Example.com") // 偏移 12，Example 位于 16
+	b = append(b, 0, 1, 0, 1)
+	// 整个域名用指针
+	b = append(b, 0xC0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 1, 1, 1, 1)
+	// 标签加指针
+	b = append(b, 1, 'a', 0xC0, 16, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 1, 0, 0, 1)
+	m, err := Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Answer[0].Name; got != "www.Example.com" {
+		t.Errorf("第一条记录名为 %q", got)
+	}
+	if got := m.Answer[1].Name; got != "a.Example.com" {
+		t.Errorf("第二条记录名为 %q", got)
+	}
+	if !reflect.DeepEqual(m.Answer[1].Data, []byte{1, 0, 0, 1}) {
+		t.Errorf("第二条记录数据为 %v", m.Answer[1].Data)
+	}
+}
+
+func TestUnpackMalformed(t *testing.T) {
+	header := func(qd, an byte) []byte { return []byte{0, 1, 0, 0, 0, qd, 0, an, 0, 0, 0, 0} }
+	question := func() []byte {
+		b, _ := AppendName(header(1, 0), "a.test")
+		return append(b, 0, 1, 0, 1)
+	}
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"空报文", nil},
+		{"头部不完整", header(0, 0)[:11]},
+		{"缺少问题段", header(1, 0)},
+		{"标签超出报文", append(header(1, 0), 5, 'a', 'b')},
+		{"问题段缺少类型", question()[:len(question())-2]},
+		{"标签长度无效", append(header(1, 0), 0x40, 'a', 0, 0, 1, 0, 1)},
+		{"压缩指针循环", append(header(1, 0), 0xC0, 12, 0, 1, 0, 1)},
+		{"压缩指针不完整", append(header(1, 0), 0xC0)},
+		{"缺少应答记录", func() []byte { b := question(); b[7] = 1; return b }()},
+		{"记录数据超出报文", func() []byte {
+			b := question()
+			b[7] = 1
+			return append(b, 0xC0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 1, 1)
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, err := Unpack(tt.b); err == nil {
+				t.Errorf("应返回错误，得到 %+v", m)
+			}
+		})
+	}
+}
+
+func TestAppendNameInvalid(t *testing.T) {
+	for _, name := range []string{"a..test", strings.Repeat("a", 64) + ".test", strings.Repeat("abcdefg.", 32) + "test"} {
+		if _, err := AppendName(nil, name); err == nil {
+			t.Errorf("AppendName(%q) 应返回错误", name)
+		}
+	}
+	for _, name := range []string{"", ".", "a.test."} {
+		if _, err := AppendName(nil, name); err != nil {
+			t.Errorf("AppendName(%q): %v", name, err)
+		}
+	}
+}
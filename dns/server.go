@@ -0,0 +1,224 @@
+package dns
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server 简易 DNS 服务器，同时监听 UDP 和 TCP：
+// 配置的域名用当前记录应答 A/AAAA 查询，其他查询原样转发到 Upstream
+type Server struct {
+	Addr     string // 监听地址，如 127.0.0.1:53
+	Upstream string // 上游 DNS 地址，如 1.1.1.1:53，为空时拒绝其他查询
+	TTL      uint32
+
+	mu      sync.RWMutex
+	records map[string][]net.IP
+
+	packetConn net.PacketConn
+	listener   net.Listener
+}
+
+func NewServer(addr, upstream string, ttl uint32) *Server {
+	if upstream != "" {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+	}
+	return &Server{Addr: addr, Upstream: upstream, TTL: ttl, records: make(map[string][]net.IP)}
+}
+
+// SetRecords 替换应答的记录
+func (s *Server) SetRecords(entries []utils.HostsEntry) {
+	records := make(map[string][]net.IP)
+	for _, entry := range entries {
+		ip := net.ParseIP(entry.IP)
+		if ip == nil {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(entry.Host, "."))
+		records[name] = append(records[name], ip)
+	}
+	s.mu.Lock()
+	s.records = records
+	s.mu.Unlock()
+}
+
+func (s *Server) lookup(name string) ([]net.IP, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ips, ok := s.records[strings.ToLower(name)]
+	return ips, ok
+}
+
+// Listen 绑定 UDP 和 TCP 端口，端口为 0 时 TCP 使用与 UDP 相同的随机端口，Addr 更新为实际地址
+func (s *Server) Listen() error {
+	packetConn, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+	s.packetConn, s.listener = packetConn, listener
+	s.Addr = packetConn.LocalAddr().String()
+	return nil
+}
+
+// Serve 在 Listen 绑定的端口上应答，直到 ctx 结束
+func (s *Server) Serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.packetConn.Close()
+		s.listener.Close()
+	}()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.serveUDP(ctx, s.packetConn)
+	}()
+	go func() {
+		defer wg.Done()
+		s.serveTCP(ctx, s.listener)
+	}()
+	wg.Wait()
+}
+
+// ListenAndServe 开始监听，直到 ctx 结束
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	err := s.Listen()
+	if err != nil {
+		return err
+	}
+	s.Serve(ctx)
+	return nil
+}
+
+func (s *Server) serveUDP(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go func() {
+			resp := s.handle(ctx, "udp", req)
+			if resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			// 一个连接上可以有多个查询，空闲 10 秒后断开
+			for {
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				req, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp := s.handle(ctx, "tcp", req)
+				if resp == nil || writeTCPMessage(conn, resp) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// 处理一个查询，返回 nil 表示不应答
+func (s *Server) handle(ctx context.Context, network string, req []byte) []byte {
+	m, err := Unpack(req)
+	if err != nil || m.Flags&FlagQR != 0 {
+		return nil
+	}
+	if m.Opcode() != OpcodeQuery || len(m.Question) != 1 {
+		return pack(m.Reply(RcodeNotImp))
+	}
+	q := m.Question[0]
+	if ips, ok := s.lookup(q.Name); ok && q.Class == ClassINET {
+		return s.truncate(network, m, pack(s.answer(m, ips)))
+	}
+	if s.Upstream == "" {
+		return pack(m.Reply(RcodeRefused))
+	}
+	resp, err := ExchangeRaw(ctx, network, s.Upstream, req)
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "[DNS] 转发 %s 到 %s 失败：%v\n", q.Name, s.Upstream, err)
+		return pack(m.Reply(RcodeServFail))
+	}
+	return s.truncate(network, m, resp)
+}
+
+// UDP 应答超过客户端能接收的长度（512 字节，或 EDNS 声明的大小）时，
+// 改为只有问题段并设置 TC 的应答，客户端会改用 TCP 重试
+func (s *Server) truncate(network string, m *Message, resp []byte) []byte {
+	if network != "udp" || len(resp) <= udpSize(m) {
+		return resp
+	}
+	r := m.Reply(RcodeSuccess)
+	r.Flags |= FlagTC | FlagRA
+	return pack(r)
+}
+
+// 客户端可接收的 UDP 报文长度
+func udpSize(m *Message) int {
+	for _, rr := range m.Additional {
+		if rr.Type == TypeOPT && rr.Class > 512 {
+			return int(rr.Class)
+		}
+	}
+	return 512
+}
+
+// 用配置的记录应答，域名存在但没有对应类型的记录时返回空应答
+func (s *Server) answer(m *Message, ips []net.IP) *Message {
+	r := m.Reply(RcodeSuccess)
+	r.Flags |= FlagAA | FlagRA
+	q := m.Question[0]
+	for _, ip := range ips {
+		rr := RR{Name: q.Name, Class: ClassINET, TTL: s.TTL}
+		if ip4 := ip.To4(); ip4 != nil {
+			rr.Type, rr.Data = TypeA, ip4
+		} else {
+			rr.Type, rr.Data = TypeAAAA, ip.To16()
+		}
+		if q.Type == rr.Type || q.Type == TypeANY {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	return r
+}
+
+func pack(m *Message) []byte {
+	b, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
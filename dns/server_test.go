@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"CloudflareSpeedTest/utils"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// 在 127.0.0.1 的随机端口上启动服务器
+func startServer(t *testing.T, upstream string, entries []utils.HostsEntry) *Server {
+	t.Helper()
+	s := NewServer("127.0.0.1:0", upstream, 60)
+	s.SetRecords(entries)
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s
+}
+
+// 对所有查询返回 NXDOMAIN 的上游，同时监听 UDP 和 TCP
+func startNXDomainUpstream(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", conn.LocalAddr().String())
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		listener.Close()
+	})
+	nxdomain := func(req []byte) []byte {
+		m, err := Unpack(req)
+		if err != nil {
+			return nil
+		}
+		return pack(m.Reply(RcodeNXDomain))
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(nxdomain(buf[:n]), addr)
+		}
+	}()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if req, err := readTCPMessage(c); err == nil {
+				writeTCPMessage(c, nxdomain(req))
+			}
+			c.Close()
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func query(t *testing.T, network, addr, name string, qtype uint16) *Message {
+	t.Helper()
+	m := &Message{ID: 0xBEEF, Flags: FlagRD, Question: []Question{{Name: name, Type: qtype, Class: ClassINET}}}
+	r, err := Exchange(context.Background(), network, addr, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestServerAnswers(t *testing.T) {
+	s := startServer(t, startNXDomainUpstream(t), []utils.HostsEntry{
+		{IP: "104.16.1.1", Host: "cdn.example.com"},
+		{IP: "2606:4700::1", Host: "cdn.example.com"},
+		{IP: "104.16.2.2", Host: "v4.example.com"},
+	})
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  uint16
+		answer []string
+	}{
+		{"A", "cdn.example.com", TypeA, RcodeSuccess, []string{"104.16.1.1"}},
+		{"AAAA", "cdn.example.com", TypeAAAA, RcodeSuccess, []string{"2606:4700::1"}},
+		{"忽略大小写", "CDN.Example.com", TypeA, RcodeSuccess, []string{"104.16.1.1"}},
+		{"没有该类型的记录", "v4.example.com", TypeAAAA, RcodeSuccess, nil},
+		{"转发到上游", "other.example.com", TypeA, RcodeNXDomain, nil},
+	}
+	for _, network := range []string{"udp", "tcp"} {
+		for _, tt := range tests {
+			t.Run(network+"/"+tt.name, func(t *testing.T) {
+				r := query(t, network, s.Addr, tt.qname, tt.qtype)
+				if r.Rcode() != tt.rcode {
+					t.Fatalf("rcode = %d, want %d", r.Rcode(), tt.rcode)
+				}
+				var got []string
+				for _, rr := range r.Answer {
+					if rr.Type != tt.qtype || rr.TTL != 60 || rr.Name != tt.qname {
+						t.Errorf("记录不符：%+v", rr)
+					}
+					got = append(got, net.IP(rr.Data).String())
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.answer) {
+					t.Errorf("answer = %v, want %v", got, tt.answer)
+				}
+				if tt.rcode == RcodeSuccess && r.Flags&FlagAA == 0 {
+					t.Errorf("应设置 AA")
+				}
+			})
+		}
+	}
+}
+
+func TestServerRefusedWithoutUpstream(t *testing.T) {
+	s := startServer(t, "", nil)
+	if r := query(t, "udp", s.Addr, "other.example.com", TypeA); r.Rcode() != RcodeRefused {
+		t.Errorf("rcode = %d, want %d", r.Rcode(), RcodeRefused)
+	}
+}
+
+func TestServerTruncatesUDP(t *testing.T) {
+	var entries []utils.HostsEntry
+	for i := 0; i < 40; i++ {
+		entries = append(entries, utils.HostsEntry{IP: fmt.Sprintf("104.16.0.%d", i), Host: "many.example.com"})
+	}
+	s := startServer(t, "", entries)
+	req := &Message{ID: 1, Question: []Question{{Name: "many.example.com", Type: TypeA, Class: ClassINET}}}
+	b, err := ExchangeRaw(context.Background(), "udp", s.Addr, pack(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Unpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > 512 || r.Flags&FlagTC == 0 || len(r.Answer) != 0 {
+		t.Errorf("UDP 应答应被截断：%d 字节，flags %#x", len(b), r.Flags)
+	}
+	// EDNS 声明了足够大的缓冲区时不截断
+	req.Additional = []RR{{Type: TypeOPT, Class: 4096}}
+	if b, err = ExchangeRaw(context.Background(), "udp", s.Addr, pack(req)); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = Unpack(b); err != nil || r.Flags&FlagTC != 0 || len(r.Answer) != 40 {
+		t.Errorf("EDNS 应答不应被截断：flags %#x，%d 条记录", r.Flags, len(r.Answer))
+	}
+	// Exchange 收到 TC 后改用 TCP
+	if r := query(t, "udp", s.Addr, "many.example.com", TypeA); len(r.Answer) != 40 {
+		t.Errorf("改用 TCP 后应有 40 条记录，得到 %d", len(r.Answer))
+	}
+}
+
+func TestServerListenError(t *testing.T) {
+	s := startServer(t, "", nil)
+	if err := NewServer(s.Addr, "", 60).Listen(); err == nil {
+		t.Errorf("端口已被占用时 Listen 应返回错误")
+	}
+}
@@ -0,0 +1,215 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+const tsigFudge = 300
+
+// TSIG 密钥，Algorithm 为 hmac-sha256、hmac-sha512、hmac-sha1 或 hmac-md5
+type TSIGKey struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+// 算法在报文中的名称和对应的哈希函数
+func (k *TSIGKey) algorithm() (string, func() hash.Hash, error) {
+	switch strings.ToLower(strings.TrimSuffix(k.Algorithm, ".")) {
+	case "", "hmac-sha256":
+		return "hmac-sha256", sha256.New, nil
+	case "hmac-sha512":
+		return "hmac-sha512", sha512.New, nil
+	case "hmac-sha1":
+		return "hmac-sha1", sha1.New, nil
+	case "hmac-md5", "hmac-md5.sig-alg.reg.int":
+		return "hmac-md5.sig-alg.reg.int", md5.New, nil
+	}
+	return "", nil, fmt.Errorf("不支持的 TSIG 算法 [%s]", k.Algorithm)
+}
+
+// 计算 MAC：请求 MAC（仅响应）+ 不含 TSIG 的报文 + TSIG 变量
+func (k *TSIGKey) mac(requestMAC, msg []byte, timeSigned uint64, fudge, tsigError uint16, other []byte) ([]byte, error) {
+	algorithm, newHash, err := k.algorithm()
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(newHash, k.Secret)
+	if requestMAC != nil {
+		h.Write(appendUint16(nil, uint16(len(requestMAC))))
+		h.Write(requestMAC)
+	}
+	h.Write(msg)
+	b, err := AppendName(nil, strings.ToLower(k.Name))
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, ClassANY)
+	b = appendUint32(b, 0)
+	b, err = AppendName(b, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, uint16(timeSigned>>32))
+	b = appendUint32(b, uint32(timeSigned))
+	b = appendUint16(b, fudge)
+	b = appendUint16(b, tsigError)
+	b = appendUint16(b, uint16(len(other)))
+	b = append(b, other...)
+	h.Write(b)
+	return h.Sum(nil), nil
+}
+
+// PackSigned 编码报文并附加 TSIG 记录，返回报文和其 MAC（用于校验响应）
+func (m *Message) PackSigned(key *TSIGKey, now time.Time) ([]byte, []byte, error) {
+	return m.packSigned(key, nil, now)
+}
+
+// 签名响应时 requestMAC 为请求的 MAC
+func (m *Message) packSigned(key *TSIGKey, requestMAC []byte, now time.Time) ([]byte, []byte, error) {
+	msg, err := m.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	algorithm, _, err := key.algorithm()
+	if err != nil {
+		return nil, nil, err
+	}
+	timeSigned := uint64(now.Unix())
+	mac, err := key.mac(requestMAC, msg, timeSigned, tsigFudge, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := AppendName(nil, algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	data = appendUint16(data, uint16(timeSigned>>32))
+	data = appendUint32(data, uint32(timeSigned))
+	data = appendUint16(data, tsigFudge)
+	data = appendUint16(data, uint16(len(mac)))
+	data = append(data, mac...)
+	data = appendUint16(data, m.ID)
+	data = appendUint16(data, 0) // error
+	data = appendUint16(data, 0) // other len
+	rr := RR{Name: strings.ToLower(key.Name), Type: TypeTSIG, Class: ClassANY, Data: data}
+	msg, err = rr.append(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(m.Additional)+1))
+	return msg, mac, nil
+}
+
+// 解析后的 TSIG RDATA
+type tsigRecord struct {
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	error      uint16
+	other      []byte
+}
+
+func parseTSIG(data []byte) (*tsigRecord, error) {
+	_, off, err := readName(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if off+10 > len(data) {
+		return nil, errShortMessage
+	}
+	t := &tsigRecord{
+		timeSigned: uint64(binary.BigEndian.Uint16(data[off:]))<<32 | uint64(binary.BigEndian.Uint32(data[off+2:])),
+		fudge:      binary.BigEndian.Uint16(data[off+6:]),
+	}
+	macSize := int(binary.BigEndian.Uint16(data[off+8:]))
+	off += 10
+	if off+macSize+6 > len(data) {
+		return nil, errShortMessage
+	}
+	t.mac = data[off : off+macSize]
+	off += macSize
+	t.originalID = binary.BigEndian.Uint16(data[off:])
+	t.error = binary.BigEndian.Uint16(data[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(data[off+4:]))
+	off += 6
+	if off+otherLen > len(data) {
+		return nil, errShortMessage
+	}
+	t.other = data[off : off+otherLen]
+	return t, nil
+}
+
+// 返回最后一条附加记录的起始偏移
+func lastRROffset(b []byte, m *Message) (int, error) {
+	off := 12
+	for range m.Question {
+		_, n, err := readName(b, off)
+		if err != nil {
+			return 0, err
+		}
+		off = n + 4
+	}
+	total := len(m.Answer) + len(m.Authority) + len(m.Additional)
+	for i := 0; i < total-1; i++ {
+		_, n, err := readRR(b, off)
+		if err != nil {
+			return 0, err
+		}
+		off = n
+	}
+	return off, nil
+}
+
+// VerifyResponse 校验响应的 TSIG 签名，requestMAC 为请求的 MAC
+func (k *TSIGKey) VerifyResponse(resp []byte, requestMAC []byte) error {
+	return k.verifyResponse(resp, requestMAC, time.Now())
+}
+
+func (k *TSIGKey) verifyResponse(resp []byte, requestMAC []byte, now time.Time) error {
+	m, err := Unpack(resp)
+	if err != nil {
+		return err
+	}
+	if len(m.Additional) == 0 || m.Additional[len(m.Additional)-1].Type != TypeTSIG {
+		return errors.New("响应没有 TSIG 签名")
+	}
+	t, err := parseTSIG(m.Additional[len(m.Additional)-1].Data)
+	if err != nil {
+		return err
+	}
+	if t.error != 0 {
+		return fmt.Errorf("TSIG 错误码 %d", t.error)
+	}
+	off, err := lastRROffset(resp, m)
+	if err != nil {
+		return err
+	}
+	// 去掉 TSIG 记录，恢复原始 ID 和附加记录数
+	msg := append([]byte(nil), resp[:off]...)
+	binary.BigEndian.PutUint16(msg[0:], t.originalID)
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(m.Additional)-1))
+	mac, err := k.mac(requestMAC, msg, t.timeSigned, t.fudge, t.error, t.other)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, t.mac) {
+		return errors.New("响应的 TSIG 签名不匹配")
+	}
+	unix := uint64(now.Unix())
+	if unix > t.timeSigned+uint64(t.fudge) || t.timeSigned > unix+uint64(t.fudge) {
+		return errors.New("响应的 TSIG 签名时间超出允许范围")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTSIGSignVerify(t *testing.T) {
+	key := &TSIGKey{Name: "update-key.", Algorithm: "hmac-sha256", Secret: []byte("0123456789abcdef")}
+	now := time.Unix(1700000000, 0)
+	req := &Message{ID: 42, Question: []Question{{Name: "zone.test", Type: TypeSOA, Class: ClassINET}}}
+	req.SetOpcode(OpcodeUpdate)
+	_, requestMAC, err := req.PackSigned(key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := req.Reply(RcodeSuccess)
+	signed, _, err := resp.packSigned(key, requestMAC, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		key        *TSIGKey
+		resp       func() []byte
+		requestMAC []byte
+		now        time.Time
+		ok         bool
+	}{
+		{"签名有效", key, func() []byte { return signed }, requestMAC, now, true},
+		{"在允许的时间偏差内", key, func() []byte { return signed }, requestMAC, now.Add(tsigFudge * time.Second), true},
+		{"签名时间超出允许范围", key, func() []byte { return signed }, requestMAC, now.Add(10 * time.Minute), false},
+		{"签名时间早于允许范围", key, func() []byte { return signed }, requestMAC, now.Add(-10 * time.Minute), false},
+		{"密钥不同", &TSIGKey{Name: key.Name, Secret: []byte("wrong")}, func() []byte { return signed }, requestMAC, now, false},
+		{"请求 MAC 不同", key, func() []byte { return signed }, make([]byte, len(requestMAC)), now, false},
+		{"报文被修改", key, func() []byte {
+			b := append([]byte(nil), signed...)
+			b[3] ^= 0x0F // 修改 rcode
+			return b
+		}, requestMAC, now, false},
+		{"MAC 被修改", key, func() []byte {
+			b := append([]byte(nil), signed...)
+			b[len(b)-7] ^= 0xFF // MAC 的最后一个字节，其后是原始 ID、错误码和其他数据长度
+			return b
+		}, requestMAC, now, false},
+		{"没有签名", key, func() []byte { return pack(resp) }, requestMAC, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.key.verifyResponse(tt.resp(), tt.requestMAC, tt.now)
+			if tt.ok && err != nil {
+				t.Errorf("应校验通过：%v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("应校验失败")
+			}
+		})
+	}
+}
+
+func TestTSIGAlgorithms(t *testing.T) {
+	for _, algorithm := range []string{"", "hmac-sha256", "hmac-sha512", "hmac-sha1", "hmac-md5"} {
+		key := &TSIGKey{Name: "k", Algorithm: algorithm, Secret: []byte("secret")}
+		now := time.Now()
+		m := &Message{ID: 1}
+		b, _, err := m.packSigned(key, []byte{1, 2, 3}, now)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if err := key.verifyResponse(b, []byte{1, 2, 3}, now); err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+		}
+	}
+	if _, _, err := (&Message{}).PackSigned(&TSIGKey{Name: "k", Algorithm: "hmac-sha3"}, time.Now()); err == nil {
+		t.Errorf("不支持的算法应返回错误")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/dns"
+	"CloudflareSpeedTest/speedTest"
+	"CloudflareSpeedTest/utils"
+	"context"
+	"fmt"
+)
+
+// 守护/监控模式下的内置 DNS 服务器，为 nil 时不启用
+var dnsServer *dns.Server
+
+// 启动内置 DNS 服务器，先用当前 hosts 中的 IP 和上次结果应答，之后每次更新 hosts 时同步
+func startDNSServer(ctx context.Context, state *runState) {
+	server := dns.NewServer(config.Config.DNSServerAddr, config.Config.DNSServerUpstream, config.Config.DNSServerTTL)
+	bestIp := state.appliedIP
+	if bestIp == "" && len(*state.last) > 0 {
+		bestIp = (*state.last)[0].IP.String()
+	}
+	var entries []utils.HostsEntry
+	if bestIp != "" {
+		entries = utils.NewHostsEntries([]string{bestIp}, config.Config.WebHosts)
+	}
+	for _, group := range config.Config.HostGroups {
+		entries = append(entries, state.last.HostGroupEntries(group, speedTest.GroupSwitch{})...)
+	}
+	server.SetRecords(entries)
+	err := server.Listen()
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "[DNS] 监听 %s 失败：%v\n", server.Addr, err)
+		return
+	}
+	dnsServer = server
+	go server.Serve(ctx)
+	if server.Upstream == "" {
+		fmt.Fprintf(config.InfoOutput, "[DNS] 在 %s 上提供 DNS 服务，拒绝其他查询\n", server.Addr)
+	} else {
+		fmt.Fprintf(config.InfoOutput, "[DNS] 在 %s 上提供 DNS 服务，其他查询转发到 %s\n", server.Addr, server.Upstream)
+	}
+}
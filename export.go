@@ -0,0 +1,129 @@
+package main
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/exporter"
+	"CloudflareSpeedTest/speedTest"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 导出使用的 IP 列表，与上次相同时守护模式不重写文件，避免延迟、速度和时间的小幅变化反复改写
+func exportKey(results []*speedTest.SpeedResult) string {
+	ips := make([]string, len(results))
+	for i, sr := range results {
+		ips[i] = sr.IP.String()
+	}
+	return strings.Join(ips, ",")
+}
+
+// 根据模板生成代理客户端配置，IP 不变或内容不变时不写入
+func exportProxyConfigs(s *speedTest.SpeedResultSlice, state *runState) {
+	for _, export := range config.Config.ProxyExports {
+		e, err := exporter.NewProxyExport(export.Format, export.Template, export.Output, export.Node, export.Port)
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			continue
+		}
+		e.DryRun = config.DryRun
+		count := export.Count
+		if count <= 0 {
+			count = 5
+		}
+		results := s.Top(export.Family, count)
+		key := exportKey(results)
+		if last, ok := state.exported[export.Output]; ok && last == key {
+			continue
+		}
+		changed, err := e.Update(results)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "生成代理配置 %s 失败：%v\n", export.Output, err)
+			continue
+		}
+		state.exported[export.Output] = key
+		if changed {
+			fmt.Fprintf(config.InfoOutput, "[信息] 已生成代理配置 %s\n", export.Output)
+		}
+	}
+}
+
+// 生成 nginx upstream / HAProxy backend，IP 不变时不重写，内容变化时执行重载命令
+func exportUpstreams(s *speedTest.SpeedResultSlice, state *runState) {
+	for _, export := range config.Config.UpstreamExports {
+		e, err := exporter.NewUpstreamExport(export.Format, export.Output, export.Name, export.Port, export.ServerOptions, export.ReloadCommand)
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			continue
+		}
+		e.DryRun = config.DryRun
+		count := export.Count
+		if count <= 0 {
+			count = 5
+		}
+		results := s.Top(export.Family, count)
+		key := exportKey(results)
+		if last, ok := state.exported[export.Output]; ok && last == key {
+			continue
+		}
+		changed, err := e.Update(results)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "生成负载均衡配置 %s 失败：%v\n", export.Output, err)
+			continue
+		}
+		state.exported[export.Output] = key
+		if changed {
+			fmt.Fprintf(config.InfoOutput, "[信息] 已生成负载均衡配置 %s\n", export.Output)
+		}
+	}
+}
+
+// 本轮的测试方式
+func runMode() string {
+	if len(config.Config.Stages) == 0 {
+		return config.Config.TestMode
+	}
+	var modes []string
+	for _, stage := range config.Config.Stages {
+		modes = append(modes, stage.Mode)
+	}
+	return strings.Join(modes, "+")
+}
+
+// 用模板生成文件，模板数据为保存的结果 state.last、本轮全部结果 s 的元数据和各地区码的汇总；
+// 保存的结果中 IP 不变时不重写，避免 .Run 中的时间每轮都改变文件
+func exportTemplates(s *speedTest.SpeedResultSlice, state *runState, start time.Time) {
+	if len(config.Config.TemplateExports) == 0 {
+		return
+	}
+	results := state.last.Top("any", 0)
+	key := exportKey(results)
+	run := exporter.RunInfo{
+		Version: config.Version,
+		Mode:    runMode(),
+		Start:   start,
+		End:     time.Now(),
+	}
+	run.Tested, run.Succeeded = countTested(s)
+	data := exporter.NewTemplateData(run, results)
+	for _, export := range config.Config.TemplateExports {
+		if last, ok := state.exported[export.Output]; ok && last == key {
+			continue
+		}
+		e, err := exporter.NewTemplateExport(export.Template, export.Output)
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			continue
+		}
+		e.DryRun = config.DryRun
+		changed, err := e.Update(data)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "生成 %s 失败：%v\n", export.Output, err)
+			continue
+		}
+		state.exported[export.Output] = key
+		if changed {
+			fmt.Fprintf(config.InfoOutput, "[信息] 已生成 %s\n", export.Output)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package exporter
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"CloudflareSpeedTest/utils"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 代理客户端配置格式：节点列表所在的键、节点名称的键、引用节点名称的位置，以及是否为 JSON
+type proxyFormat struct {
+	nodesKey   string
+	nameKey    string
+	references []string // 以 . 分隔的键，[] 表示列表中的每一项
+	json       bool
+}
+
+var proxyFormats = map[string]proxyFormat{
+	"clash": {nodesKey: "proxies", nameKey: "name", references: []string{
+		"proxies[].dialer-proxy",
+		"proxy-groups[].proxies",
+	}},
+	"sing-box": {nodesKey: "outbounds", nameKey: "tag", json: true, references: []string{
+		"outbounds[].detour",
+		"outbounds[].outbounds",
+		"outbounds[].default",
+		"route.final",
+		"route.rules[].outbound",
+		"dns.servers[].detour",
+	}},
+	"xray": {nodesKey: "outbounds", nameKey: "tag", json: true, references: []string{
+		"outbounds[].proxySettings.tag",
+		"routing.rules[].outboundTag",
+		"routing.balancers[].selector",
+		"routing.balancers[].fallbackTag",
+		"observatory.subjectSelector",
+		"burstObservatory.subjectSelector",
+	}},
+}
+
+// ProxyExport 以模板中的一个节点为原型，为每个 IP 生成一个节点，
+// 节点名称为 "原名称 地区码 延迟ms"，模板中引用原型节点的位置改为引用生成的节点
+type ProxyExport struct {
+	Format   string // clash, sing-box or xray
+	Template string
+	Output   string
+	Node     string // 原型节点的名称，为空时使用第一个有服务器地址的节点
+	Port     int    // 0 表示保留模板中的端口
+	DryRun   bool
+}
+
+func NewProxyExport(format, template, output, node string, port int) (*ProxyExport, error) {
+	if _, ok := proxyFormats[format]; !ok {
+		return nil, fmt.Errorf("不支持的代理配置格式 [%s]，可选 clash / sing-box / xray", format)
+	}
+	if template == "" || output == "" {
+		return nil, fmt.Errorf("代理配置格式 [%s] 需要指定模板和输出路径", format)
+	}
+	return &ProxyExport{Format: format, Template: template, Output: output, Node: node, Port: port}, nil
+}
+
+// 节点的服务器地址所在的映射和端口的键：Clash 和 sing-box 在节点上，
+// Xray 在 settings.vnext[0] 或 settings.servers[0] 上
+func (e *ProxyExport) serverOf(node *yaml.Node) (*yaml.Node, string, string) {
+	switch e.Format {
+	case "clash":
+		if mappingValue(node, "server") != nil {
+			return node, "server", "port"
+		}
+	case "sing-box":
+		if mappingValue(node, "server") != nil {
+			return node, "server", "server_port"
+		}
+	case "xray":
+		settings := mappingValue(node, "settings")
+		for _, key := range []string{"vnext", "servers"} {
+			servers := mappingValue(settings, key)
+			if servers != nil && servers.Kind == yaml.SequenceNode && len(servers.Content) > 0 {
+				return servers.Content[0], "address", "port"
+			}
+		}
+	}
+	return nil, "", ""
+}
+
+// Render 读取模板并生成配置内容
+func (e *ProxyExport) Render(results []*speedTest.SpeedResult) (string, error) {
+	format := proxyFormats[e.Format]
+	data, err := os.ReadFile(e.Template)
+	if err != nil {
+		return "", err
+	}
+	var doc yaml.Node
+	if format.json {
+		root, err := parseJSON(data)
+		if err != nil {
+			return "", fmt.Errorf("解析模板 %s 失败：%v", e.Template, err)
+		}
+		doc = *root
+	} else if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("解析模板 %s 失败：%v", e.Template, err)
+	}
+	if len(doc.Content) == 0 {
+		return "", fmt.Errorf("模板 %s 为空", e.Template)
+	}
+	nodes := mappingValue(doc.Content[0], format.nodesKey)
+	if nodes == nil || nodes.Kind != yaml.SequenceNode {
+		return "", fmt.Errorf("模板 %s 中没有 %s 列表", e.Template, format.nodesKey)
+	}
+	index := -1
+	for i, node := range nodes.Content {
+		server, _, _ := e.serverOf(node)
+		name := mappingValue(node, format.nameKey)
+		if server != nil && name != nil && (e.Node == "" || name.Value == e.Node) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return "", fmt.Errorf("模板 %s 中没有找到原型节点 [%s]", e.Template, e.Node)
+	}
+	prototype := nodes.Content[index]
+	prototypeName := mappingValue(prototype, format.nameKey).Value
+
+	var clones []*yaml.Node
+	var names []string
+	used := make(map[string]bool)
+	for _, sr := range results {
+		name := prototypeName
+		if sr.Colo != "" {
+			name += " " + sr.Colo
+		}
+		name += fmt.Sprintf(" %dms", sr.Delay.Milliseconds())
+		base := name
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s #%d", base, i)
+		}
+		used[name] = true
+		node := cloneNode(prototype)
+		setScalar(node, format.nameKey, name, "!!str")
+		server, addressKey, portKey := e.serverOf(node)
+		setScalar(server, addressKey, sr.IP.String(), "!!str")
+		if e.Port > 0 {
+			setScalar(server, portKey, strconv.Itoa(e.Port), "!!int")
+		}
+		clones = append(clones, node)
+		names = append(names, name)
+	}
+	if len(clones) == 0 {
+		return "", fmt.Errorf("没有可用于生成节点的 IP")
+	}
+	// 先去掉原型节点再替换引用，最后在原位置插入生成的节点
+	rest := nodes.Content[index+1:]
+	nodes.Content = append(nodes.Content[:index:index], rest...)
+	for _, path := range format.references {
+		replaceReferences(doc.Content[0], strings.Split(path, "."), prototypeName, names)
+	}
+	nodes.Content = append(append(nodes.Content[:index:index], clones...), nodes.Content[index:]...)
+
+	if format.json {
+		var b bytes.Buffer
+		err = encodeJSON(&b, &doc, "")
+		b.WriteString("\n")
+		return b.String(), err
+	}
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), enc.Close()
+}
+
+// 把 keys 指向的位置中对原型节点的引用改为引用生成的节点：列表中展开为全部节点，单个值改为第一个节点
+func replaceReferences(n *yaml.Node, keys []string, name string, names []string) {
+	key := strings.TrimSuffix(keys[0], "[]")
+	v := mappingValue(n, key)
+	if v == nil {
+		return
+	}
+	if len(keys) > 1 {
+		if key == keys[0] {
+			replaceReferences(v, keys[1:], name, names)
+		} else if v.Kind == yaml.SequenceNode {
+			for _, child := range v.Content {
+				replaceReferences(child, keys[1:], name, names)
+			}
+		}
+		return
+	}
+	switch v.Kind {
+	case yaml.ScalarNode:
+		if v.Value == name {
+			*v = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: names[0]}
+		}
+	case yaml.SequenceNode:
+		var content []*yaml.Node
+		for _, child := range v.Content {
+			if child.Kind == yaml.ScalarNode && child.Value == name {
+				for _, newName := range names {
+					content = append(content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: newName})
+				}
+				continue
+			}
+			content = append(content, child)
+		}
+		v.Content = content
+	}
+}
+
+// Update 生成配置并在内容变化时写入，返回是否有变化
+func (e *ProxyExport) Update(results []*speedTest.SpeedResult) (bool, error) {
+	content, err := e.Render(results)
+	if err != nil {
+		return false, err
+	}
+	return utils.WriteFileIfChanged(e.Output, content, e.DryRun)
+}
@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testResults() []*speedTest.SpeedResult {
+	return []*speedTest.SpeedResult{
+		{IP: &net.IPAddr{IP: net.ParseIP("104.16.1.1")}, Colo: "HKG", Delay: 50 * time.Millisecond},
+		{IP: &net.IPAddr{IP: net.ParseIP("104.16.2.2")}, Colo: "LAX", Delay: 150 * time.Millisecond},
+	}
+}
+
+func renderTemplate(t *testing.T, format, template string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "template")
+	if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewProxyExport(format, path, path+".out", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := e.Render(testResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestProxyExportClashReferences(t *testing.T) {
+	out := renderTemplate(t, "clash", `proxies:
+  - name: cf
+    type: vless
+    server: example.com
+    port: 443
+proxy-groups:
+  - name: auto
+    type: url-test
+    proxies: [cf, DIRECT]
+rules:
+  - MATCH,auto
+x-comment: cf
+`)
+	for _, want := range []string{
+		"- name: cf HKG 50ms\n    type: vless\n    server: 104.16.1.1",
+		"- name: cf LAX 150ms\n    type: vless\n    server: 104.16.2.2",
+		"proxies: [cf HKG 50ms, cf LAX 150ms, DIRECT]",
+		"x-comment: cf\n", // 不是节点引用的位置保持不变
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出中没有 %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestProxyExportSingBoxReferences(t *testing.T) {
+	out := renderTemplate(t, "sing-box", `{
+  "log": {"level": "cf"},
+  "outbounds": [
+    {"type": "selector", "tag": "select", "outbounds": ["cf", "direct"], "default": "cf"},
+    {"type": "vless", "tag": "cf", "server": "example.com", "server_port": 443},
+    {"type": "direct", "tag": "direct"}
+  ],
+  "route": {"rules": [{"domain": ["cf"], "outbound": "cf"}], "final": "cf"}
+}`)
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(out)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"outbounds":["cf HKG 50ms","cf LAX 150ms","direct"]`,
+		`"default":"cf HKG 50ms"`,
+		`"outbound":"cf HKG 50ms"`,
+		`"final":"cf HKG 50ms"`,
+		`"level":"cf"`, // 不是节点引用的位置保持不变
+		`"domain":["cf"]`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("输出中没有 %s:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package exporter
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"CloudflareSpeedTest/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// RunInfo 本轮测速的元数据
+type RunInfo struct {
+	Version   string
+	Mode      string // 延迟测试方式，分阶段时为各阶段方式以 + 连接
+	Start     time.Time
+	End       time.Time
+	Tested    int // 完成测试的 IP 数量
+	Succeeded int // 其中测试成功的 IP 数量
+}
+
+// ColoSummary 一个地区码的汇总
+type ColoSummary struct {
+	Colo        string
+	Count       int
+	MinDelay    time.Duration
+	AvgDelay    time.Duration
+	AvgLossRate float32
+	MaxSpeed    float64 // 字节/秒
+	Best        *speedTest.SpeedResult
+}
+
+// TemplateData 模板的数据：.Run 为元数据，.Results 为排名后的结果，.Colos 为各地区码的汇总
+type TemplateData struct {
+	Run     RunInfo
+	Results []*speedTest.SpeedResult
+	Colos   []ColoSummary
+}
+
+func NewTemplateData(run RunInfo, results []*speedTest.SpeedResult) *TemplateData {
+	return &TemplateData{Run: run, Results: results, Colos: summarizeColos(results)}
+}
+
+// 按地区码汇总，结果数量多的在前；没有地区码的结果不参与汇总
+func summarizeColos(results []*speedTest.SpeedResult) []ColoSummary {
+	index := make(map[string]int)
+	var summaries []ColoSummary
+	var totalDelay []time.Duration
+	var totalLoss []float32
+	for _, sr := range results {
+		if sr.Colo == "" {
+			continue
+		}
+		i, ok := index[sr.Colo]
+		if !ok {
+			i = len(summaries)
+			index[sr.Colo] = i
+			summaries = append(summaries, ColoSummary{Colo: sr.Colo, MinDelay: sr.Delay, Best: sr})
+			totalDelay = append(totalDelay, 0)
+			totalLoss = append(totalLoss, 0)
+		}
+		c := &summaries[i]
+		c.Count++
+		totalDelay[i] += sr.Delay
+		totalLoss[i] += sr.GetLossRate()
+		if sr.Delay < c.MinDelay {
+			c.MinDelay = sr.Delay
+		}
+		if sr.DownloadSpeed > c.MaxSpeed {
+			c.MaxSpeed = sr.DownloadSpeed
+		}
+	}
+	for i := range summaries {
+		summaries[i].AvgDelay = totalDelay[i] / time.Duration(summaries[i].Count)
+		summaries[i].AvgLossRate = totalLoss[i] / float32(summaries[i].Count)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].Count == summaries[j].Count {
+			return summaries[i].Colo < summaries[j].Colo
+		}
+		return summaries[i].Count > summaries[j].Count
+	})
+	return summaries
+}
+
+// 模板中可用的函数
+var templateFuncs = template.FuncMap{
+	"ms": func(d time.Duration) int64 { return d.Milliseconds() },
+	"mb": func(speed float64) string { return fmt.Sprintf("%.2f", speed/1024/1024) },
+	"percent": func(rate float32) string {
+		return fmt.Sprintf("%.2f%%", rate*100)
+	},
+	"join": strings.Join,
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// TemplateExport 用 text/template 模板生成任意格式的文件
+type TemplateExport struct {
+	Template string
+	Output   string
+	DryRun   bool
+}
+
+func NewTemplateExport(template, output string) (*TemplateExport, error) {
+	if template == "" || output == "" {
+		return nil, fmt.Errorf("模板导出需要指定模板和输出路径")
+	}
+	return &TemplateExport{Template: template, Output: output}, nil
+}
+
+// Render 执行模板
+func (e *TemplateExport) Render(data *TemplateData) (string, error) {
+	t, err := template.New(filepath.Base(e.Template)).Funcs(templateFuncs).ParseFiles(e.Template)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Update 内容变化时写入，返回是否有变化
+func (e *TemplateExport) Update(data *TemplateData) (bool, error) {
+	content, err := e.Render(data)
+	if err != nil {
+		return false, err
+	}
+	return utils.WriteFileIfChanged(e.Output, content, e.DryRun)
+}
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSummarizeColos(t *testing.T) {
+	result := func(ip, colo string, delay time.Duration, received int, speed float64) *speedTest.SpeedResult {
+		return &speedTest.SpeedResult{IP: &net.IPAddr{IP: net.ParseIP(ip)}, Colo: colo, Sended: 4, Received: received, Delay: delay, DownloadSpeed: speed}
+	}
+	results := []*speedTest.SpeedResult{
+		result("104.16.1.1", "LAX", 60*time.Millisecond, 4, 10),
+		result("104.16.1.2", "HKG", 40*time.Millisecond, 4, 20),
+		result("104.16.1.3", "LAX", 80*time.Millisecond, 2, 30),
+		result("104.16.1.4", "", 10*time.Millisecond, 4, 90), // 没有地区码，不参与汇总
+		result("104.16.1.5", "SJC", 90*time.Millisecond, 4, 5),
+	}
+	colos := NewTemplateData(RunInfo{}, results).Colos
+	if len(colos) != 3 || colos[0].Colo != "LAX" || colos[1].Colo != "HKG" || colos[2].Colo != "SJC" {
+		t.Fatalf("应按数量、再按地区码排序: %+v", colos)
+	}
+	lax := colos[0]
+	if lax.Count != 2 || lax.MinDelay != 60*time.Millisecond || lax.AvgDelay != 70*time.Millisecond ||
+		lax.AvgLossRate != 0.25 || lax.MaxSpeed != 30 || lax.Best != results[0] {
+		t.Errorf("LAX 汇总 = %+v", lax)
+	}
+}
+
+func TestTemplateExport(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "report.tmpl")
+	const text = `{{.Run.Version}} {{.Run.Mode}} {{.Run.Succeeded}}/{{.Run.Tested}}
+{{range .Results}}{{.IP}} {{ms .Delay}} {{mb .DownloadSpeed}} {{percent .GetLossRate}}
+{{end}}{{range .Colos}}{{.Colo}}={{.Count}} {{end}}
+{{json .Run.Start}}`
+	if err := os.WriteFile(tmpl, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewTemplateExport(tmpl, filepath.Join(dir, "report.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := testResults()
+	results[0].Sended, results[0].Received, results[0].DownloadSpeed = 4, 3, 5*1024*1024
+	run := RunInfo{
+		Version:   "v2.0.0",
+		Mode:      "tcping+httping",
+		Start:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Tested:    10,
+		Succeeded: 2,
+	}
+	data := NewTemplateData(run, results)
+	want := "v2.0.0 tcping+httping 2/10\n" +
+		"104.16.1.1 50 5.00 25.00%\n" +
+		"104.16.2.2 150 0.00 100.00%\n" +
+		"HKG=1 LAX=1 \n" +
+		`"2026-01-02T03:04:05Z"`
+	got, err := e.Render(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if changed, err := e.Update(data); err != nil || !changed {
+		t.Fatalf("第一次写入 changed = %v, err = %v", changed, err)
+	}
+	if changed, err := e.Update(data); err != nil || changed {
+		t.Fatalf("内容不变时不应写入: changed = %v, err = %v", changed, err)
+	}
+
+	if _, err := NewTemplateExport("", "out"); err == nil {
+		t.Fatal("缺少模板时应返回错误")
+	}
+}
@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"CloudflareSpeedTest/utils"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxWeight  = 100
+	weightStep = 10 // 权重按 10 取整，测速结果的小幅波动不会改变文件内容
+)
+
+// UpstreamExport 生成 nginx upstream 块或 HAProxy backend 段，
+// 内容变化时写入并执行 ReloadCommand；DryRun 时只输出 diff
+type UpstreamExport struct {
+	Format        string // nginx or haproxy
+	Output        string
+	Name          string // upstream / backend 名称
+	Port          int
+	ServerOptions string // 追加到每个 server 行的参数，如 nginx 的 max_fails=2 或 HAProxy 的 check
+	ReloadCommand string
+	DryRun        bool
+}
+
+func NewUpstreamExport(format, output, name string, port int, serverOptions, reloadCommand string) (*UpstreamExport, error) {
+	switch format {
+	case "nginx", "haproxy":
+	default:
+		return nil, fmt.Errorf("不支持的负载均衡配置格式 [%s]，可选 nginx / haproxy", format)
+	}
+	if output == "" {
+		return nil, fmt.Errorf("负载均衡配置格式 [%s] 未指定输出路径", format)
+	}
+	if name == "" {
+		name = "cloudflare"
+	}
+	if port <= 0 {
+		port = 443
+	}
+	return &UpstreamExport{Format: format, Output: output, Name: name, Port: port, ServerOptions: serverOptions, ReloadCommand: reloadCommand}, nil
+}
+
+// Weights 按得分计算权重，得分最高的为 maxWeight，其余按比例取整到 weightStep 的倍数，最低为 weightStep：
+// 全部有下载速度时得分为下载速度，否则为延迟的倒数，再乘以 (1 - 丢包率)
+func Weights(results []*speedTest.SpeedResult) []int {
+	bySpeed := len(results) > 0
+	for _, sr := range results {
+		if sr.DownloadSpeed <= 0 {
+			bySpeed = false
+		}
+	}
+	scores := make([]float64, len(results))
+	var best float64
+	for i, sr := range results {
+		if bySpeed {
+			scores[i] = sr.DownloadSpeed
+		} else if sr.Delay > 0 {
+			scores[i] = 1 / sr.Delay.Seconds()
+		}
+		scores[i] *= float64(1 - sr.GetLossRate())
+		best = math.Max(best, scores[i])
+	}
+	weights := make([]int, len(results))
+	for i, score := range scores {
+		weights[i] = 1
+		if best > 0 {
+			weights[i] = int(math.Max(1, math.Round(score/best*maxWeight/weightStep))) * weightStep
+		}
+	}
+	return weights
+}
+
+// Render 生成配置内容
+func (e *UpstreamExport) Render(results []*speedTest.SpeedResult) string {
+	var b strings.Builder
+	b.WriteString("# Generated by CloudflareSpeedTest, do not edit\n")
+	if e.Format == "nginx" {
+		fmt.Fprintf(&b, "upstream %s {\n", e.Name)
+	} else {
+		fmt.Fprintf(&b, "backend %s\n    balance roundrobin\n", e.Name)
+	}
+	options := ""
+	if e.ServerOptions != "" {
+		options = " " + e.ServerOptions
+	}
+	for i, weight := range Weights(results) {
+		sr := results[i]
+		// 注释只写地区码，延迟的小幅波动不会改变文件内容
+		comment := ""
+		if sr.Colo != "" {
+			comment = " # " + sr.Colo
+		}
+		if e.Format == "nginx" {
+			address := net.JoinHostPort(sr.IP.String(), strconv.Itoa(e.Port))
+			fmt.Fprintf(&b, "    server %s weight=%d%s;%s\n", address, weight, options, comment)
+		} else {
+			// HAProxy 以最后一个冒号分隔端口，IPv6 地址不加方括号
+			address := sr.IP.String() + ":" + strconv.Itoa(e.Port)
+			fmt.Fprintf(&b, "    server cf%d %s weight %d%s%s\n", i+1, address, weight, options, comment)
+		}
+	}
+	if e.Format == "nginx" {
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// Update 内容变化时写入并执行 ReloadCommand，返回是否有变化
+func (e *UpstreamExport) Update(results []*speedTest.SpeedResult) (bool, error) {
+	if len(results) == 0 {
+		return false, fmt.Errorf("没有可用于生成 %s 的 IP", e.Output)
+	}
+	changed, err := utils.WriteFileIfChanged(e.Output, e.Render(results), e.DryRun)
+	if err != nil || !changed || e.DryRun || e.ReloadCommand == "" {
+		return changed, err
+	}
+	err = utils.RunShellCommand(e.ReloadCommand)
+	if err != nil {
+		return changed, fmt.Errorf("执行重载命令 [%s] 失败：%v", e.ReloadCommand, err)
+	}
+	return changed, nil
+}
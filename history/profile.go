@@ -0,0 +1,89 @@
+package history
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"time"
+)
+
+// HourProfile 一个 IP 按一天中各小时（本地时间）汇总的历史表现
+type HourProfile struct {
+	hours [24]accumulator
+}
+
+// HourlyProfiles 按 IP 建立每小时的表现
+func HourlyProfiles(records []speedTest.JSONResult) map[string]*HourProfile {
+	profiles := make(map[string]*HourProfile)
+	for i := range records {
+		r := &records[i]
+		p := profiles[r.IP]
+		if p == nil {
+			p = &HourProfile{}
+			profiles[r.IP] = p
+		}
+		p.hours[r.TestedAt.Local().Hour()].add(r)
+	}
+	return profiles
+}
+
+// windowHours [start, end) 覆盖的小时，最多 24 个
+func windowHours(start, end time.Time) []int {
+	var hours []int
+	seen := [24]bool{}
+	start = start.Local()
+	// 按本地时间取整到小时，Truncate 按 UTC 取整，+05:30 等时区会错位
+	start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, time.Local)
+	for t := start; t.Before(end) && len(hours) < 24; t = t.Add(time.Hour) {
+		if h := t.Hour(); !seen[h] {
+			seen[h] = true
+			hours = append(hours, h)
+		}
+	}
+	return hours
+}
+
+// Window 汇总 [start, end) 覆盖的各小时的历史记录，不区分日期
+func (p *HourProfile) Window(start, end time.Time) IPStats {
+	var a accumulator
+	for _, h := range windowHours(start, end) {
+		b := &p.hours[h]
+		a.count += b.count
+		a.success += b.success
+		a.speedCount += b.speedCount
+		a.delays = append(a.delays, b.delays...)
+		a.speeds = append(a.speeds, b.speeds...)
+		a.loss += b.loss
+		a.speed += b.speed
+		if b.maxSpeed > a.maxSpeed {
+			a.maxSpeed = b.maxSpeed
+		}
+	}
+	st := IPStats{
+		Count:      a.count,
+		Success:    a.success,
+		AvgDelayMs: a.avgDelay(),
+		AvgSpeed:   a.avgSpeed(),
+		MaxSpeed:   a.maxSpeed,
+	}
+	if a.count > 0 {
+		st.AvgLossRate = a.loss / float64(a.count)
+	}
+	return st
+}
+
+// Forecasts 用历史记录预测各 IP 在 [start, end) 内的表现，窗口内成功次数少于 minSamples 的 IP 不预测
+func Forecasts(records []speedTest.JSONResult, start, end time.Time, minSamples int) map[string]speedTest.Forecast {
+	forecasts := make(map[string]speedTest.Forecast)
+	for ip, p := range HourlyProfiles(records) {
+		st := p.Window(start, end)
+		if st.Success == 0 || st.Success < minSamples {
+			continue
+		}
+		forecasts[ip] = speedTest.Forecast{
+			Delay:         time.Duration(st.AvgDelayMs * float64(time.Millisecond)),
+			LossRate:      float32(st.AvgLossRate),
+			DownloadSpeed: st.AvgSpeed,
+			Samples:       st.Success,
+		}
+	}
+	return forecasts
+}
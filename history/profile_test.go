@@ -0,0 +1,60 @@
+package history
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWindowHours(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 1, day, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []int
+	}{
+		{"跨越整点", at(2, 10, 30), at(2, 12, 0), []int{10, 11}},
+		{"跨越午夜", at(2, 23, 0), at(3, 1, 0), []int{23, 0}},
+		{"超过一天时最多 24 个", at(2, 5, 0), at(4, 5, 0), []int{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 0, 1, 2, 3, 4}},
+		{"空窗口", at(2, 10, 0), at(2, 10, 0), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windowHours(tt.start, tt.end); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("windowHours = %v，应为 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForecasts(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2026, 1, day, hour, 15, 0, 0, time.Local)
+	}
+	records := []speedTest.JSONResult{
+		// 1.1.1.1 在 10 点前后表现好，15 点表现差
+		{IP: "1.1.1.1", Sent: 4, Received: 4, DelayMs: 100, DownloadSpeed: 2000, TestedAt: at(1, 10)},
+		{IP: "1.1.1.1", Sent: 4, Received: 4, DelayMs: 140, TestedAt: at(2, 10)},
+		{IP: "1.1.1.1", Sent: 4, Received: 0, LossRate: 1, TestedAt: at(3, 10)},
+		{IP: "1.1.1.1", Sent: 4, Received: 4, DelayMs: 900, DownloadSpeed: 100, TestedAt: at(2, 15)},
+		// 1.0.0.1 在窗口内只有一次成功
+		{IP: "1.0.0.1", Sent: 4, Received: 4, DelayMs: 50, TestedAt: at(1, 10)},
+		{IP: "1.0.0.1", Sent: 4, Received: 4, DelayMs: 50, TestedAt: at(1, 15)},
+	}
+	got := Forecasts(records, at(4, 10), at(4, 11), 2)
+	if len(got) != 1 {
+		t.Fatalf("Forecasts = %v，应只有 1.1.1.1", got)
+	}
+	f, ok := got["1.1.1.1"]
+	want := speedTest.Forecast{Delay: 120 * time.Millisecond, LossRate: float32(1) / 3, DownloadSpeed: 2000, Samples: 2}
+	if !ok || f != want {
+		t.Errorf("Forecast = %+v，应为 %+v", f, want)
+	}
+
+	if got := Forecasts(records, at(4, 15), at(4, 16), 1); got["1.0.0.1"].Delay != 50*time.Millisecond || got["1.1.1.1"].Delay != 900*time.Millisecond {
+		t.Errorf("15 点的预测 = %+v", got)
+	}
+}
@@ -0,0 +1,234 @@
+package history
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/speedTest"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// IPStats 一个 IP 在一段时间内的汇总，延迟只统计测试成功的记录
+type IPStats struct {
+	IP          string    `json:"ip"`
+	Colo        string    `json:"colo,omitempty"`
+	Count       int       `json:"count"`
+	Success     int       `json:"success"`
+	AvgDelayMs  float64   `json:"avg_delay_ms"`
+	AvgLossRate float64   `json:"avg_loss_rate"`
+	AvgSpeed    float64   `json:"avg_download_speed"` // 只统计测了下载速度的记录，字节/秒
+	MaxSpeed    float64   `json:"max_download_speed"`
+	LastTested  time.Time `json:"last_tested_at"`
+}
+
+// DayStats 一个地区码一天的汇总
+type DayStats struct {
+	Day           string  `json:"day"`
+	Count         int     `json:"count"`
+	Success       int     `json:"success"`
+	MedianDelayMs float64 `json:"median_delay_ms"`
+	AvgLossRate   float64 `json:"avg_loss_rate"`
+	AvgSpeed      float64 `json:"avg_download_speed"`
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// 累加器，计算平均延迟、丢包率和下载速度
+type accumulator struct {
+	count, success, speedCount int
+	delays, speeds             []float64
+	loss, speed, maxSpeed      float64
+}
+
+func (a *accumulator) add(r *speedTest.JSONResult) {
+	a.count++
+	a.loss += float64(r.LossRate)
+	if r.Received > 0 {
+		a.success++
+		a.delays = append(a.delays, r.DelayMs)
+	}
+	if r.DownloadSpeed > 0 {
+		a.speedCount++
+		a.speed += r.DownloadSpeed
+		a.speeds = append(a.speeds, r.DownloadSpeed)
+		if r.DownloadSpeed > a.maxSpeed {
+			a.maxSpeed = r.DownloadSpeed
+		}
+	}
+}
+
+func (a *accumulator) avgDelay() float64 {
+	var total float64
+	for _, d := range a.delays {
+		total += d
+	}
+	if len(a.delays) == 0 {
+		return 0
+	}
+	return total / float64(len(a.delays))
+}
+
+func (a *accumulator) avgSpeed() float64 {
+	if a.speedCount == 0 {
+		return 0
+	}
+	return a.speed / float64(a.speedCount)
+}
+
+// SummarizeIPs 按 IP 汇总记录
+func SummarizeIPs(records []speedTest.JSONResult) []IPStats {
+	index := make(map[string]int)
+	var stats []IPStats
+	var accs []*accumulator
+	for i := range records {
+		r := &records[i]
+		j, ok := index[r.IP]
+		if !ok {
+			j = len(stats)
+			index[r.IP] = j
+			stats = append(stats, IPStats{IP: r.IP})
+			accs = append(accs, &accumulator{})
+		}
+		accs[j].add(r)
+		if r.Colo != "" {
+			stats[j].Colo = r.Colo
+		}
+		if r.TestedAt.After(stats[j].LastTested) {
+			stats[j].LastTested = r.TestedAt
+		}
+	}
+	for j := range stats {
+		a := accs[j]
+		stats[j].Count = a.count
+		stats[j].Success = a.success
+		stats[j].AvgDelayMs = a.avgDelay()
+		stats[j].AvgLossRate = a.loss / float64(a.count)
+		stats[j].AvgSpeed = a.avgSpeed()
+		stats[j].MaxSpeed = a.maxSpeed
+	}
+	return stats
+}
+
+// BestIPs 按平均下载速度、平均延迟、平均丢包率排序，返回前 n 个至少成功过一次的 IP
+func BestIPs(records []speedTest.JSONResult, n int) []IPStats {
+	var stats []IPStats
+	for _, st := range SummarizeIPs(records) {
+		if st.Success > 0 {
+			stats = append(stats, st)
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].AvgSpeed != stats[j].AvgSpeed {
+			return stats[i].AvgSpeed > stats[j].AvgSpeed
+		}
+		if stats[i].AvgDelayMs != stats[j].AvgDelayMs {
+			return stats[i].AvgDelayMs < stats[j].AvgDelayMs
+		}
+		return stats[i].AvgLossRate < stats[j].AvgLossRate
+	})
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// ColoTrend 按天（本地时间）汇总一个地区码的记录
+func ColoTrend(records []speedTest.JSONResult) []DayStats {
+	index := make(map[string]*accumulator)
+	var days []string
+	for i := range records {
+		day := records[i].TestedAt.Local().Format("2006-01-02")
+		if index[day] == nil {
+			index[day] = &accumulator{}
+			days = append(days, day)
+		}
+		index[day].add(&records[i])
+	}
+	sort.Strings(days)
+	var stats []DayStats
+	for _, day := range days {
+		a := index[day]
+		stats = append(stats, DayStats{
+			Day:           day,
+			Count:         a.count,
+			Success:       a.success,
+			MedianDelayMs: median(a.delays),
+			AvgLossRate:   a.loss / float64(a.count),
+			AvgSpeed:      a.avgSpeed(),
+		})
+	}
+	return stats
+}
+
+// 以 config.PrintFormat 输出，json / ndjson 输出到 config.ResultOutput，否则调用 table 输出表格
+func printFormat(v interface{}, items int, item func(i int) interface{}, table func()) {
+	switch config.PrintFormat {
+	case "json":
+		enc := json.NewEncoder(config.ResultOutput)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+	case "ndjson":
+		enc := json.NewEncoder(config.ResultOutput)
+		for i := 0; i < items; i++ {
+			enc.Encode(item(i))
+		}
+	default:
+		table()
+	}
+}
+
+func mb(speed float64) float64 { return speed / 1024 / 1024 }
+
+// PrintIPHistory 输出一个 IP 的全部记录
+func PrintIPHistory(records []speedTest.JSONResult) {
+	printFormat(records, len(records), func(i int) interface{} { return records[i] }, func() {
+		if len(records) == 0 {
+			fmt.Fprintln(config.InfoOutput, "[信息] 没有该 IP 的历史记录")
+			return
+		}
+		fmt.Fprintf(config.InfoOutput, "\033[34m%-22s%-8s%-8s%-8s%-10s%-16s%-8s\033[0m\n", "测试时间", "方式", "已发送", "丢包率", "平均延迟", "下载速度(MB/s)", "地区码")
+		for _, r := range records {
+			fmt.Fprintf(config.InfoOutput, "%-26s%-10s%-11d%-11.2f%-14.2f%-20.2f%-8s\n", r.TestedAt.Local().Format("2006-01-02 15:04:05"), r.Mode, r.Sent, r.LossRate, r.DelayMs, mb(r.DownloadSpeed), r.Colo)
+		}
+	})
+}
+
+// PrintIPStats 输出 IP 汇总
+func PrintIPStats(stats []IPStats) {
+	printFormat(stats, len(stats), func(i int) interface{} { return stats[i] }, func() {
+		if len(stats) == 0 {
+			fmt.Fprintln(config.InfoOutput, "[信息] 时间范围内没有可用的历史记录")
+			return
+		}
+		fmt.Fprintf(config.InfoOutput, "\033[34m%-40s%-6s%-8s%-10s%-8s%-16s%-5s\033[0m\n", "IP 地址", "次数", "成功", "平均延迟", "丢包率", "下载速度(MB/s)", "地区码")
+		for _, st := range stats {
+			fmt.Fprintf(config.InfoOutput, "%-42s%-8d%-10d%-14.2f%-11.2f%-20.2f%-8s\n", st.IP, st.Count, st.Success, st.AvgDelayMs, st.AvgLossRate, mb(st.AvgSpeed), st.Colo)
+		}
+	})
+}
+
+// PrintColoTrend 输出地区码每天的趋势
+func PrintColoTrend(stats []DayStats) {
+	printFormat(stats, len(stats), func(i int) interface{} { return stats[i] }, func() {
+		if len(stats) == 0 {
+			fmt.Fprintln(config.InfoOutput, "[信息] 时间范围内没有该地区码的历史记录")
+			return
+		}
+		fmt.Fprintf(config.InfoOutput, "\033[34m%-12s%-6s%-8s%-10s%-8s%-16s\033[0m\n", "日期", "次数", "成功", "延迟中位数", "丢包率", "下载速度(MB/s)")
+		for _, st := range stats {
+			fmt.Fprintf(config.InfoOutput, "%-14s%-8d%-10d%-15.2f%-11.2f%-20.2f\n", st.Day, st.Count, st.Success, st.MedianDelayMs, st.AvgLossRate, mb(st.AvgSpeed))
+		}
+	})
+}
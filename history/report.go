@@ -0,0 +1,183 @@
+package history
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/speedTest"
+	"CloudflareSpeedTest/utils"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ColoReport 一个地区码在时间范围内的汇总
+type ColoReport struct {
+	Colo          string    `json:"colo"`
+	IPs           int       `json:"ips"`
+	Count         int       `json:"count"`
+	Success       int       `json:"success"`
+	MedianDelayMs float64   `json:"median_delay_ms"`
+	MedianSpeed   float64   `json:"median_download_speed"` // 只统计测了下载速度的记录，字节/秒
+	AvgLossRate   float64   `json:"avg_loss_rate"`
+	Top           []IPStats `json:"top"`
+}
+
+// GrowthPoint 一天结束时黑白名单的大小及与前一天相比的变化
+type GrowthPoint struct {
+	Day        string `json:"day"`
+	Runs       int    `json:"runs"`
+	Allow      uint64 `json:"allow"`
+	Deny       uint64 `json:"deny"`
+	AllowDelta int64  `json:"allow_delta"`
+	DenyDelta  int64  `json:"deny_delta"`
+}
+
+// Report 最近一轮测速、各地区码、各网段和黑白名单增长的汇总
+type Report struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Since       time.Time         `json:"since"`
+	LastRun     *RunRecord        `json:"last_run,omitempty"`
+	Colos       []ColoReport      `json:"colos"`
+	CIDRs       []utils.CIDRStats `json:"cidrs"`
+	Growth      []GrowthPoint     `json:"growth"`
+}
+
+// NewReport 汇总 since 之后的历史记录和测速记录，每个地区码列出最好的 top 个 IP
+func NewReport(records []speedTest.JSONResult, runs []RunRecord, cidrs []utils.CIDRStats, since, now time.Time, top int) *Report {
+	r := &Report{GeneratedAt: now, Since: since, CIDRs: cidrs}
+	if len(runs) > 0 {
+		r.LastRun = &runs[len(runs)-1]
+	}
+	r.Colos = coloReports(records, top)
+	r.Growth = growth(runs)
+	return r
+}
+
+func coloReports(records []speedTest.JSONResult, top int) []ColoReport {
+	byColo := make(map[string][]speedTest.JSONResult)
+	for _, rec := range records {
+		byColo[rec.Colo] = append(byColo[rec.Colo], rec)
+	}
+	var reports []ColoReport
+	for colo, recs := range byColo {
+		var a accumulator
+		ips := make(map[string]bool)
+		for i := range recs {
+			a.add(&recs[i])
+			ips[recs[i].IP] = true
+		}
+		reports = append(reports, ColoReport{
+			Colo:          colo,
+			IPs:           len(ips),
+			Count:         a.count,
+			Success:       a.success,
+			MedianDelayMs: median(a.delays),
+			MedianSpeed:   median(a.speeds),
+			AvgLossRate:   a.loss / float64(a.count),
+			Top:           BestIPs(recs, top),
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Count != reports[j].Count {
+			return reports[i].Count > reports[j].Count
+		}
+		return reports[i].Colo < reports[j].Colo
+	})
+	return reports
+}
+
+// 每天取最后一轮的黑白名单大小，第一天与当天第一轮比较
+func growth(runs []RunRecord) []GrowthPoint {
+	var points []GrowthPoint
+	var prevAllow, prevDeny uint64
+	for i, run := range runs {
+		day := run.StartedAt.Local().Format("2006-01-02")
+		if i == 0 {
+			prevAllow, prevDeny = run.Allow, run.Deny
+		}
+		if len(points) == 0 || points[len(points)-1].Day != day {
+			if len(points) > 0 {
+				last := points[len(points)-1]
+				prevAllow, prevDeny = last.Allow, last.Deny
+			}
+			points = append(points, GrowthPoint{Day: day})
+		}
+		p := &points[len(points)-1]
+		p.Runs++
+		p.Allow, p.Deny = run.Allow, run.Deny
+		p.AllowDelta = int64(run.Allow) - int64(prevAllow)
+		p.DenyDelta = int64(run.Deny) - int64(prevDeny)
+	}
+	return points
+}
+
+func coloName(colo string) string {
+	if colo == "" {
+		return "N/A"
+	}
+	return colo
+}
+
+func topIPs(stats []IPStats) string {
+	var ips []string
+	for _, st := range stats {
+		ips = append(ips, fmt.Sprintf("%s(%.0fms)", st.IP, st.AvgDelayMs))
+	}
+	return strings.Join(ips, " ")
+}
+
+// Print 按 config.PrintFormat 输出：json、markdown，其他为表格
+func (r *Report) Print() {
+	switch config.PrintFormat {
+	case "json":
+		enc := json.NewEncoder(config.ResultOutput)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+	case "markdown":
+		r.printMarkdown()
+	default:
+		r.printTable()
+	}
+}
+
+func (r *Report) printTable() {
+	fmt.Fprintf(config.InfoOutput, "统计时间: %s 至 %s\n", r.Since.Local().Format("2006-01-02 15:04"), r.GeneratedAt.Local().Format("2006-01-02 15:04"))
+	if run := r.LastRun; run != nil {
+		fmt.Fprintf(config.InfoOutput, "\n最近一轮: %s 方式 %s，测试 %d 个，成功 %d 个，最优 IP %s，白名单 %d，黑名单 %d\n",
+			run.StartedAt.Local().Format("2006-01-02 15:04:05"), run.Mode, run.Tested, run.Succeeded, run.BestIP, run.Allow, run.Deny)
+	}
+	fmt.Fprintf(config.InfoOutput, "\n\033[34m%-8s%-6s%-8s%-8s%-10s%-16s%-8s%s\033[0m\n", "地区码", "IP数", "次数", "成功", "延迟中位数", "速度中位数(MB/s)", "丢包率", "最好的 IP")
+	for _, c := range r.Colos {
+		fmt.Fprintf(config.InfoOutput, "%-11s%-8d%-10d%-10d%-15.2f%-24.2f%-11.2f%s\n", coloName(c.Colo), c.IPs, c.Count, c.Success, c.MedianDelayMs, mb(c.MedianSpeed), c.AvgLossRate, topIPs(c.Top))
+	}
+	fmt.Fprintf(config.InfoOutput, "\n\033[34m%-20s%-12s%-10s%-10s%-10s%-8s%-8s\033[0m\n", "网段", "地址数", "已扫描", "白名单", "黑名单", "覆盖率", "成功率")
+	for _, c := range r.CIDRs {
+		fmt.Fprintf(config.InfoOutput, "%-22s%-15d%-13d%-13d%-13d%-11.2f%-11.2f\n", c.CIDR, c.Size, c.Scanned, c.Allow, c.Deny, c.Coverage, c.SuccessRatio)
+	}
+	fmt.Fprintf(config.InfoOutput, "\n\033[34m%-12s%-6s%-10s%-10s%-10s%-10s\033[0m\n", "日期", "轮数", "白名单", "新增", "黑名单", "新增")
+	for _, g := range r.Growth {
+		fmt.Fprintf(config.InfoOutput, "%-14s%-8d%-13d%-12d%-13d%-12d\n", g.Day, g.Runs, g.Allow, g.AllowDelta, g.Deny, g.DenyDelta)
+	}
+}
+
+func (r *Report) printMarkdown() {
+	w := config.ResultOutput
+	fmt.Fprintf(w, "# CloudflareSpeedTest 报告\n\n统计时间: %s 至 %s\n", r.Since.Local().Format("2006-01-02 15:04"), r.GeneratedAt.Local().Format("2006-01-02 15:04"))
+	if run := r.LastRun; run != nil {
+		fmt.Fprintf(w, "\n## 最近一轮\n\n| 开始时间 | 方式 | 测试 | 成功 | 最优 IP | 白名单 | 黑名单 |\n|---|---|---:|---:|---|---:|---:|\n")
+		fmt.Fprintf(w, "| %s | %s | %d | %d | %s | %d | %d |\n", run.StartedAt.Local().Format("2006-01-02 15:04:05"), run.Mode, run.Tested, run.Succeeded, run.BestIP, run.Allow, run.Deny)
+	}
+	fmt.Fprintf(w, "\n## 地区码\n\n| 地区码 | IP 数 | 次数 | 成功 | 延迟中位数(ms) | 速度中位数(MB/s) | 丢包率 | 最好的 IP |\n|---|---:|---:|---:|---:|---:|---:|---|\n")
+	for _, c := range r.Colos {
+		fmt.Fprintf(w, "| %s | %d | %d | %d | %.2f | %.2f | %.2f | %s |\n", coloName(c.Colo), c.IPs, c.Count, c.Success, c.MedianDelayMs, mb(c.MedianSpeed), c.AvgLossRate, topIPs(c.Top))
+	}
+	fmt.Fprintf(w, "\n## 网段\n\n| 网段 | 地址数 | 已扫描 | 白名单 | 黑名单 | 覆盖率 | 成功率 |\n|---|---:|---:|---:|---:|---:|---:|\n")
+	for _, c := range r.CIDRs {
+		fmt.Fprintf(w, "| %s | %d | %d | %d | %d | %.2f%% | %.2f%% |\n", c.CIDR, c.Size, c.Scanned, c.Allow, c.Deny, c.Coverage*100, c.SuccessRatio*100)
+	}
+	fmt.Fprintf(w, "\n## 黑白名单增长\n\n| 日期 | 轮数 | 白名单 | 新增 | 黑名单 | 新增 |\n|---|---:|---:|---:|---:|---:|\n")
+	for _, g := range r.Growth {
+		fmt.Fprintf(w, "| %s | %d | %d | %+d | %d | %+d |\n", g.Day, g.Runs, g.Allow, g.AllowDelta, g.Deny, g.DenyDelta)
+	}
+}
@@ -0,0 +1,79 @@
+package history
+
+import (
+	"CloudflareSpeedTest/config"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RunRecord 一轮测速的汇总
+type RunRecord struct {
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	Mode        string    `json:"mode"`
+	Tested      int       `json:"tested"`
+	Succeeded   int       `json:"succeeded"`
+	Allow       uint64    `json:"allow"` // 本轮结束后白名单的地址数
+	Deny        uint64    `json:"deny"`
+	BestIP      string    `json:"best_ip,omitempty"`
+	Interrupted bool      `json:"interrupted,omitempty"`
+}
+
+// RunLog 只追加的每轮测速汇总，每行一条 RunRecord
+type RunLog struct {
+	Path string
+}
+
+func NewRunLog(path string) *RunLog {
+	return &RunLog{Path: path}
+}
+
+// Append 追加一轮的汇总
+func (l *RunLog) Append(r RunRecord) error {
+	fp, err := os.OpenFile(l.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(fp).Encode(r)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Load 按文件顺序读取 since 之后开始的记录，无法解析的行跳过
+func (l *RunLog) Load(since time.Time) ([]RunRecord, error) {
+	fp, err := os.Open(l.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var runs []RunRecord
+	scanner := bufio.NewScanner(fp)
+	skipped := 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.StartedAt.IsZero() {
+			skipped++
+			continue
+		}
+		if r.StartedAt.Before(since) {
+			continue
+		}
+		runs = append(runs, r)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(config.InfoOutput, "[信息] 测速记录文件[%s]中有 %d 行无法解析，已跳过\n", l.Path, skipped)
+	}
+	return runs, scanner.Err()
+}
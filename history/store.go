@@ -0,0 +1,111 @@
+package history
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/speedTest"
+	"CloudflareSpeedTest/utils"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Store 只追加的测速历史，每行一条 speedTest.JSONResult
+type Store struct {
+	Path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{Path: path}
+}
+
+// Append 追加本轮测试过的结果（合并历史前的原始测量），没有测试时间的结果使用 now
+func (h *Store) Append(s *speedTest.SpeedResultSlice, now time.Time) error {
+	fp, err := os.OpenFile(h.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fp)
+	enc := json.NewEncoder(w)
+	for i := 0; i < len(*s); i++ {
+		if !(*s)[i].Tested() {
+			continue
+		}
+		r := (*s)[i].Measurement()
+		if r.TestedAt.IsZero() {
+			r.TestedAt = now
+		}
+		err = enc.Encode(r)
+		if err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Load 按文件顺序读取 since 之后且满足 filter 的记录，filter 为 nil 表示全部；无法解析的行跳过
+func (h *Store) Load(since time.Time, filter func(*speedTest.JSONResult) bool) ([]speedTest.JSONResult, error) {
+	fp, err := os.Open(h.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var records []speedTest.JSONResult
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	skipped := 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r speedTest.JSONResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.IP == "" {
+			skipped++
+			continue
+		}
+		if r.TestedAt.Before(since) || (filter != nil && !filter(&r)) {
+			continue
+		}
+		records = append(records, r)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(config.InfoOutput, "[信息] 历史文件[%s]中有 %d 行无法解析，已跳过\n", h.Path, skipped)
+	}
+	return records, scanner.Err()
+}
+
+// Compact 删除 before 之前的记录，返回删除的行数；没有要删除的记录时不改写文件，无法解析的行保留
+func (h *Store) Compact(before time.Time) (int, error) {
+	data, err := os.ReadFile(h.Path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	kept := make([]byte, 0, len(data))
+	removed := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var r speedTest.JSONResult
+		if json.Unmarshal(line, &r) == nil && r.IP != "" && r.TestedAt.Before(before) {
+			removed++
+			continue
+		}
+		kept = append(kept, line...)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, utils.WriteFileAtomic(h.Path, kept, 0644)
+}
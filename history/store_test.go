@@ -0,0 +1,54 @@
+package history
+
+import (
+	"CloudflareSpeedTest/speedTest"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.ndjson")
+	store := NewStore(path)
+	now := time.Now()
+	for _, age := range []time.Duration{48 * time.Hour, 2 * time.Hour, 0} {
+		s := speedTest.NewSpeedResultSlice([]*net.IPAddr{{IP: net.ParseIP("104.16.1.1")}})
+		(*s)[0].Sended, (*s)[0].Received = 3, 3
+		(*s)[0].TestedAt = now.Add(-age)
+		if err := store.Append(s, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.Close()
+
+	removed, err := store.Compact(now.Add(-24 * time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("removed = %d, err = %v", removed, err)
+	}
+	records, err := store.Load(time.Time{}, nil)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("剩余 %d 条记录，err = %v", len(records), err)
+	}
+	data, _ := os.ReadFile(path)
+	if got := string(data[len(data)-9:]); got != "not json\n" {
+		t.Errorf("无法解析的行应保留，文件末尾为 %q", got)
+	}
+
+	info, _ := os.Stat(path)
+	if removed, err = store.Compact(now.Add(-24 * time.Hour)); err != nil || removed != 0 {
+		t.Fatalf("removed = %d, err = %v", removed, err)
+	}
+	if after, _ := os.Stat(path); !after.ModTime().Equal(info.ModTime()) {
+		t.Errorf("没有要删除的记录时不应改写文件")
+	}
+	if removed, err = NewStore(path + ".missing").Compact(now); err != nil || removed != 0 {
+		t.Errorf("文件不存在时 removed = %d, err = %v", removed, err)
+	}
+}
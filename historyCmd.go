@@ -0,0 +1,191 @@
+package main
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/history"
+	"CloudflareSpeedTest/speedTest"
+	"CloudflareSpeedTest/utils"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// 追加本轮测试过的结果到历史文件，并删除超过 HistoryMaxAge 的记录
+func appendHistory(s *speedTest.SpeedResultSlice) {
+	if config.Config.HistoryFile == "" {
+		return
+	}
+	store := history.NewStore(config.Config.HistoryFile)
+	err := store.Append(s, time.Now())
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "写入历史文件[%s]失败：%v\n", config.Config.HistoryFile, err)
+		return
+	}
+	if config.Config.HistoryMaxAge <= 0 || config.DryRun {
+		return
+	}
+	_, err = store.Compact(time.Now().Add(-config.Config.HistoryMaxAge))
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "清理历史文件[%s]失败：%v\n", config.Config.HistoryFile, err)
+	}
+}
+
+func mergeOptions() speedTest.MergeOptions {
+	return speedTest.MergeOptions{
+		HalfLife:      config.Config.MergeHalfLife,
+		MaxAge:        config.Config.MergeMaxAge,
+		MinConfidence: config.Config.MergeMinConfidence,
+	}
+}
+
+// 按 IP 分组的历史测量，用于与本轮结果合并；没有配置 HistoryFile 时不合并，
+// 结果文件保存的是合并后的值，不能当作原始测量再次合并
+func mergeHistory() map[string][]speedTest.JSONResult {
+	past := map[string][]speedTest.JSONResult{}
+	if config.Config.MergeHalfLife <= 0 || config.Config.HistoryFile == "" {
+		return past
+	}
+	var since time.Time
+	if config.Config.MergeMaxAge > 0 {
+		since = time.Now().Add(-config.Config.MergeMaxAge)
+	}
+	records, err := history.NewStore(config.Config.HistoryFile).Load(since, nil)
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "读取历史文件[%s]失败：%v\n", config.Config.HistoryFile, err)
+	}
+	for _, r := range records {
+		past[r.IP] = append(past[r.IP], r)
+	}
+	return past
+}
+
+// 按各 IP 历史上同一时段的表现重新排名，时间窗口为 TimeOfDayWindow，守护模式下至少到下一轮测速
+func rankByTimeOfDay(s *speedTest.SpeedResultSlice, state *runState) {
+	if config.Config.TimeOfDayWindow <= 0 || config.Config.HistoryFile == "" {
+		return
+	}
+	start := time.Now()
+	end := start.Add(config.Config.TimeOfDayWindow)
+	if state.schedule != nil {
+		if next := state.schedule.Next(start); next.After(end) {
+			end = next
+		}
+	}
+	records, err := history.NewStore(config.Config.HistoryFile).Load(start.AddDate(0, 0, -config.Config.TimeOfDayDays), nil)
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "读取历史文件[%s]失败：%v\n", config.Config.HistoryFile, err)
+		return
+	}
+	forecasts := history.Forecasts(records, start, end, config.Config.TimeOfDayMinSamples)
+	if len(forecasts) == 0 {
+		return
+	}
+	fmt.Fprintf(config.InfoOutput, "\n[信息] 按 %s - %s 时段的历史表现排名（%d 个 IP 有足够记录）\n", start.Format("15:04"), end.Format("15:04"), len(forecasts))
+	s.SortByForecast(forecasts, config.Config.EnableDownLoadTest)
+}
+
+// 本轮完成测试和测试成功的 IP 数量
+func countTested(s *speedTest.SpeedResultSlice) (tested, succeeded int) {
+	for i := 0; i < len(*s); i++ {
+		if !(*s)[i].Tested() {
+			continue
+		}
+		tested++
+		if (*s)[i].Received > 0 {
+			succeeded++
+		}
+	}
+	return tested, succeeded
+}
+
+// 把本轮的汇总追加到 RunsFile，供 -report 统计黑白名单增长
+func appendRun(s *speedTest.SpeedResultSlice, store *utils.IPV4Store, start time.Time, interrupted bool) {
+	if config.Config.RunsFile == "" {
+		return
+	}
+	run := history.RunRecord{
+		StartedAt:   start,
+		EndedAt:     time.Now(),
+		Mode:        runMode(),
+		Allow:       store.Allow.GetCardinality(),
+		Deny:        store.Deny.GetCardinality(),
+		Interrupted: interrupted,
+	}
+	run.Tested, run.Succeeded = countTested(s)
+	for i := 0; i < len(*s); i++ {
+		if (*s)[i].Tested() && (*s)[i].Received > 0 {
+			run.BestIP = (*s)[i].IP.String()
+			break
+		}
+	}
+	err := history.NewRunLog(config.Config.RunsFile).Append(run)
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "写入测速记录文件[%s]失败：%v\n", config.Config.RunsFile, err)
+	}
+}
+
+// -report：汇总最近 -history-days 天的历史和测速记录，以及各网段的黑白名单
+func runReport() {
+	now := time.Now()
+	since := now.AddDate(0, 0, -config.HistoryDays)
+	var records []speedTest.JSONResult
+	var runs []history.RunRecord
+	var err error
+	if config.Config.HistoryFile != "" {
+		records, err = history.NewStore(config.Config.HistoryFile).Load(since, nil)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "读取历史文件[%s]失败：%v\n", config.Config.HistoryFile, err)
+		}
+	}
+	if config.Config.RunsFile != "" {
+		runs, err = history.NewRunLog(config.Config.RunsFile).Load(since)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "读取测速记录文件[%s]失败：%v\n", config.Config.RunsFile, err)
+		}
+	}
+	store := utils.LoadIPV4Store(config.Config.AllowIPV4RBFile, config.Config.DenyIPV4RBFile)
+	cidrs, err := utils.GetCIDRStats(config.Config.CIDRIPV4File, store)
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "读取网段文件[%s]失败：%v\n", config.Config.CIDRIPV4File, err)
+	}
+	history.NewReport(records, runs, cidrs, since, now, config.HistoryTop).Print()
+}
+
+// 是否为历史查询命令
+func isHistoryQuery() bool {
+	return config.HistoryIP != "" || config.HistoryBest || config.HistoryColo != ""
+}
+
+// 执行 -history-ip / -history-best / -history-colo 查询
+func runHistoryQuery() {
+	if config.Config.HistoryFile == "" {
+		fmt.Fprintln(config.InfoOutput, "未配置 HistoryFile")
+		return
+	}
+	store := history.NewStore(config.Config.HistoryFile)
+	since := time.Now().AddDate(0, 0, -config.HistoryDays)
+	var err error
+	switch {
+	case config.HistoryIP != "":
+		ip := net.ParseIP(config.HistoryIP)
+		if ip == nil {
+			fmt.Fprintf(config.InfoOutput, "IP [%s] 无效\n", config.HistoryIP)
+			return
+		}
+		var records []speedTest.JSONResult
+		records, err = store.Load(time.Time{}, func(r *speedTest.JSONResult) bool { return r.IP == ip.String() })
+		history.PrintIPHistory(records)
+	case config.HistoryBest:
+		var records []speedTest.JSONResult
+		records, err = store.Load(since, nil)
+		history.PrintIPStats(history.BestIPs(records, config.HistoryTop))
+	case config.HistoryColo != "":
+		var records []speedTest.JSONResult
+		records, err = store.Load(since, func(r *speedTest.JSONResult) bool { return strings.EqualFold(r.Colo, config.HistoryColo) })
+		history.PrintColoTrend(history.ColoTrend(records))
+	}
+	if err != nil {
+		fmt.Fprintln(config.InfoOutput, err)
+	}
+}
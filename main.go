@@ -4,73 +4,141 @@ import (
 	"CloudflareSpeedTest/config"
 	"CloudflareSpeedTest/speedTest"
 	"CloudflareSpeedTest/utils"
+	"context"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-func SpeedTest() (s *speedTest.SpeedResultSlice) {
-	ips := utils.GetIPs(
+// 守护模式下跨轮次常驻内存的状态
+type runState struct {
+	last      *speedTest.SpeedResultSlice   // 上一轮保存的结果
+	store     *utils.IPV4Store              // 黑白名单
+	appliedIP string                        // 已写入 hosts 的 IP
+	groups    map[string][]utils.HostsEntry // 各域名组上次写入的记录
+	exported  map[string]string             // 各导出文件上次使用的 IP 列表，IP 不变时不重写
+	schedule  utils.Schedule                // 守护模式的测速计划，决定按时段排名的时间窗口
+}
+
+func loadRunState() *runState {
+	last := speedTest.NewSpeedResultSlice(nil)
+	last.LoadSpeedResultSlice(config.Config.OutputFile)
+	state := &runState{
+		last:     last,
+		store:    utils.LoadIPV4Store(config.Config.AllowIPV4RBFile, config.Config.DenyIPV4RBFile),
+		groups:   make(map[string][]utils.HostsEntry),
+		exported: make(map[string]string),
+	}
+	if len(config.Config.WebHosts) > 0 {
+		state.appliedIP, _ = hostsFile().GetIP(config.Config.WebHosts)
+	}
+	// 各域名组上次写入的记录，单次运行时也能保留仍可用的 IP
+	for _, group := range config.Config.HostGroups {
+		entries, _ := hostsFile().Entries(group.Hosts)
+		if len(entries) > 0 {
+			state.groups[group.Name] = entries
+		}
+	}
+	return state
+}
+
+func workerPoolOptions() utils.WorkerPoolOptions {
+	return utils.WorkerPoolOptions{
+		RateLimit:        config.Config.ProbeRateLimit,
+		Adaptive:         config.Config.AdaptiveConcurrency,
+		MinWorkers:       config.Config.AdaptiveMinRoutines,
+		HighTimeoutRatio: config.Config.AdaptiveHighRatio,
+		LowTimeoutRatio:  config.Config.AdaptiveLowRatio,
+	}
+}
+
+func SpeedTest(ctx context.Context, state *runState) (s *speedTest.SpeedResultSlice) {
+	ips := utils.GetIPsByStore(
 		config.Config.CIDRIPV4File,
 		config.Config.TestIPNum,
-		config.Config.OutputFile,
-		config.Config.AllowIPV4RBFile,
-		config.Config.DenyIPV4RBFile,
+		state.last.IPV4Uint32s(),
+		state.store,
 	)
 	s = speedTest.NewSpeedResultSlice(ips)
-	fmt.Printf("TestMode %s\n", config.Config.TestMode)
-	switch config.Config.TestMode {
-	case "tcp":
-		s.TcpTest(
-			config.Config.TcpRoutines,
-			config.Config.TcpPort,
-			config.Config.TcpConnectTimes,
-			config.Config.TcpConnectTimeout,
-		)
-	case "http":
-		s.HttpTest(
-			config.Config.HttpColo,
-			config.Config.HttpColoSet,
-			config.Config.HttpConnectTimes,
-			config.Config.HttpConnectTimeout,
-			config.Config.HttpRoutines,
-			config.Config.HttpStatusCode,
-			config.Config.HttpURL,
-			config.Config.HttpTCPPort,
-		)
+	// 当前使用的 IP 也参与本轮测试，切换时用它的最新结果比较
+	if state.appliedIP != "" && s.Index(state.appliedIP) < 0 {
+		if ipAddr, err := net.ResolveIPAddr("ip", state.appliedIP); err == nil {
+			*s = append(*s, *speedTest.NewSpeedResultSlice([]*net.IPAddr{ipAddr})...)
+		}
 	}
-	// update ip download speed by last result
-	lastSpeedResultSlice := speedTest.NewSpeedResultSlice(nil)
-	lastSpeedResultSlice.LoadSpeedResultSlice(config.Config.OutputFile)
-	ss := speedTest.SpeedResultSet{}
-	for i := 0; i < len(*lastSpeedResultSlice); i++ {
-		ss.Add(&(*lastSpeedResultSlice)[i])
+	if len(config.Config.Stages) > 0 {
+		// 只对通过全部阶段的 IP 合并历史并重新排名，其余 IP 保持在后面
+		passed := (*s)[:runStages(ctx, s)]
+		past, opts, now := mergeHistory(), mergeOptions(), time.Now()
+		passed.MergeLatency(past, opts, now)
+		passed.MergeSpeed(past, opts, now)
+		passed.SortByDownloadSpeedDelayLossRate()
+		rankByTimeOfDay(&passed, state)
+		return s
 	}
-	for i := 0; i < len(*s); i++ {
-		ssIp := ss.Get((*s)[i].IP.String())
-		if ssIp != nil {
-			(*s)[i].DownloadSpeed = ssIp.DownloadSpeed
-		}
+	fmt.Fprintf(config.InfoOutput, "TestMode %s\n", config.Config.TestMode)
+	latencyCtx, cancel := speedTest.WithBudget(ctx, config.Config.LatencyPhaseTimeout)
+	defer cancel()
+	latencyTest(latencyCtx, config.Config.TestMode, s, speedTest.EarlyStop{
+		Count: config.Config.LatencyStopCount,
+		Threshold: speedTest.Threshold{
+			MaxDelay:    config.Config.LatencyStopMaxDelay,
+			MaxLossRate: config.Config.LatencyStopMaxLossRate,
+		},
+	})
+	if latencyCtx.Err() != nil && ctx.Err() == nil {
+		fmt.Fprintln(config.InfoOutput, "[信息] 延迟测速已用完时间预算，未测试的 IP 不参与排名")
 	}
+	// 与历史测量合并，下载测速后再合并速度
+	past, opts, now := mergeHistory(), mergeOptions(), time.Now()
+	s.MergeLatency(past, opts, now)
 	s.SortByDelayLossRate()
-	// 开始下载测速
-	if config.Config.EnableDownLoadTest {
-		fmt.Printf("Start DownloadTest %s\n", config.Config.DownloadURL)
+	// 开始下载测速，被中断时跳过
+	if config.Config.EnableDownLoadTest && ctx.Err() == nil {
+		fmt.Fprintf(config.InfoOutput, "Start DownloadTest %s\n", config.Config.DownloadURL)
+		downloadCtx, cancel := speedTest.WithBudget(ctx, config.Config.DownloadPhaseTimeout)
+		defer cancel()
 		s.DownloadTest(
+			downloadCtx,
 			config.Config.DownloadTestIPNum,
 			config.Config.DownloadIPTestTimes,
 			config.Config.DownloadTimeout,
 			config.Config.DownloadURL,
 			config.Config.DownloadTCPPort,
+			speedTest.EarlyStop{
+				Count:     config.Config.DownloadStopCount,
+				Threshold: speedTest.Threshold{MinSpeed: config.Config.DownloadStopMinSpeed * 1024 * 1024},
+			},
 		)
+		// 当前 IP 不在下载测速范围内时单独测一次
+		if i := s.Index(state.appliedIP); i >= config.Config.DownloadTestIPNum && (*s)[i].Tested() && downloadCtx.Err() == nil {
+			(*s)[i].DownloadTest(
+				downloadCtx,
+				config.Config.DownloadIPTestTimes,
+				config.Config.DownloadTimeout,
+				config.Config.DownloadURL,
+				config.Config.DownloadTCPPort,
+				nil,
+			)
+		}
 	}
+	s.MergeSpeed(past, opts, now)
 	s.SortByDownloadSpeedDelayLossRate()
+	rankByTimeOfDay(s, state)
 	return s
 }
 
-func outputResultAllowDenayIPV4(s *speedTest.SpeedResultSlice) error {
+func outputResultAllowDenayIPV4(s *speedTest.SpeedResultSlice, store *utils.IPV4Store) error {
 	allowIPV4 := []uint32{}
 	denyIPV4 := []uint32{}
 	for i := 0; i < len(*s); i++ {
 		si := (*s)[i]
+		if !si.Tested() || si.IP.IP.To4() == nil { // 未完成测试的 IP 不计入黑白名单，黑白名单只支持 IPv4
+			continue
+		}
 		isAllow := si.Delay < config.MaxAllowDelay
 		ipUint32 := utils.NetIPAddrIPV4toUint32(si.IP)
 		if isAllow {
@@ -79,30 +147,173 @@ func outputResultAllowDenayIPV4(s *speedTest.SpeedResultSlice) error {
 			denyIPV4 = append(denyIPV4, ipUint32)
 		}
 	}
-	err := utils.SaveBestAllowDenyIPV4(
-		&allowIPV4,
-		&denyIPV4,
-		config.Config.AllowIPV4RBFile,
-		config.Config.DenyIPV4RBFile,
-	)
+	store.Add(&allowIPV4, &denyIPV4)
+	err := store.Save(config.Config.AllowIPV4RBFile, config.Config.DenyIPV4RBFile)
 	if err != nil {
 		return err
 	}
 	s.SaveSpeedResultSlice(config.Config.OutputFile, config.Config.SaveIPNum)
+	s.SaveFormats(config.Config.OutputFile, config.Config.SaveIPNum, config.Config.OutputFormats)
+	return nil
+}
+
+func hostsFile() *utils.HostsFile {
+	h := utils.NewHostsFile(config.Config.HostsFile, config.Config.HostsBackupDir, config.Config.HostsBackupNum)
+	h.DryRun = config.DryRun
+	return h
+}
+
+// 更新内置 DNS 服务器的记录，写入 hosts 文件（DisableHosts 时跳过）和所有 DNSOutputs
+func applyEntries(entries []utils.HostsEntry, removeStale bool) error {
+	if dnsServer != nil { // 内置 DNS 服务器不依赖 hosts 文件，先更新
+		dnsServer.SetRecords(entries)
+	}
+	if !config.Config.DisableHosts {
+		err := hostsFile().Update(entries, removeStale) // 更新hosts文件，内容不变时不写入
+		if err != nil {
+			return err
+		}
+	}
+	for _, output := range config.Config.DNSOutputs {
+		d, err := utils.NewDNSConfigFile(output.Format, output.Path, output.ReloadCommand, output.TTL)
+		if err != nil {
+			return err
+		}
+		d.DryRun = config.DryRun
+		err = d.Update(entries)
+		if err != nil {
+			return fmt.Errorf("写入 DNS 配置 [%s] 失败：%v", output.Path, err)
+		}
+	}
 	return nil
 }
 
-func updateWebHosts(s *speedTest.SpeedResultSlice) error {
-	bestIp := (*s)[0].IP.String()
-	err := utils.UpdateHosts(bestIp, config.Config.WebHosts) // 更新hosts文件
-	return err
+// 将 WebHosts 指向 ip，域名组保持上次写入的记录
+func applyHostsIP(ip string, state *runState) error {
+	entries := utils.NewHostsEntries([]string{ip}, config.Config.WebHosts)
+	removeStale := config.Config.HostsRemoveStale
+	for _, group := range config.Config.HostGroups {
+		groupEntries, ok := state.groups[group.Name]
+		if !ok {
+			removeStale = false // 保留该组原有的记录
+		}
+		entries = append(entries, groupEntries...)
+	}
+	return applyEntries(entries, removeStale)
+}
+
+// 保留当前 IP 需要满足的条件
+func switchKeepThreshold() speedTest.Threshold {
+	keep := speedTest.Threshold{
+		MaxDelay:    config.Config.SwitchMaxDelay,
+		MaxLossRate: config.Config.SwitchMaxLossRate,
+	}
+	if config.Config.EnableDownLoadTest {
+		keep.MinSpeed = config.Config.SwitchMinSpeed * 1024 * 1024
+	}
+	return keep
+}
+
+func updateWebHosts(s *speedTest.SpeedResultSlice, state *runState) error {
+	if len(*s) == 0 || !(*s)[0].Tested() {
+		return fmt.Errorf("没有可用的测速结果，跳过更新 hosts")
+	}
+	chosen := s.ChooseIP(state.appliedIP, config.Config.SwitchMargin, switchKeepThreshold())
+	bestIp := chosen.IP.String()
+	if bestIp == state.appliedIP {
+		if bestIp != (*s)[0].IP.String() {
+			fmt.Fprintf(config.InfoOutput, "[信息] 当前 IP %s 仍然可用，最优 IP %s 的提升不足 %.0f%%，保持不变\n", bestIp, (*s)[0].IP.String(), config.Config.SwitchMargin*100)
+		} else {
+			fmt.Fprintf(config.InfoOutput, "[信息] 最优 IP 未变化 (%s)\n", bestIp)
+		}
+	}
+	for _, group := range config.Config.HostGroups {
+		groupEntries := s.HostGroupEntries(group, speedTest.GroupSwitch{
+			Previous: state.groups[group.Name],
+			Margin:   config.Config.SwitchMargin,
+			Keep:     switchKeepThreshold(),
+		})
+		if len(groupEntries) == 0 {
+			fmt.Fprintf(config.InfoOutput, "[信息] 域名组 %s 没有符合条件的 IP，保持不变\n", group.Name)
+			continue
+		}
+		state.groups[group.Name] = groupEntries
+	}
+	err := applyHostsIP(bestIp, state)
+	if err != nil {
+		return err
+	}
+	state.appliedIP = bestIp
+	return nil
+}
+
+// 执行一轮测速并保存结果，返回是否被中断
+func runCycle(signalCtx context.Context, state *runState) bool {
+	start := time.Now()
+	// 整体运行截止时间，到期后停止测速，对已测得的结果照常排名
+	ctx, cancel := speedTest.WithBudget(signalCtx, config.Config.RunTimeout)
+	defer cancel()
+	s := SpeedTest(ctx, state) // 获取下载测速结果
+	if speedTest.Interrupted(ctx) {
+		fmt.Fprintln(config.InfoOutput, "\n[信息] 测速被中断，正在保存已完成的结果...")
+	} else if ctx.Err() != nil {
+		fmt.Fprintln(config.InfoOutput, "\n[信息] 已到达运行截止时间，仅对已完成测试的 IP 排名")
+	} else {
+		fmt.Fprintln(config.InfoOutput, "SpeedTest Done")
+	}
+	// s.Print(config.Config.TestIPNum)
+	s.Print(10)
+	err := outputResultAllowDenayIPV4(s, state.store)
+	if err != nil {
+		fmt.Fprintln(config.InfoOutput, err)
+	}
+	appendHistory(s)
+	appendRun(s, state.store, start, speedTest.Interrupted(ctx))
+	if speedTest.Interrupted(ctx) { // 结果不完整，不更新 hosts
+		return true
+	}
+	state.last = s.Best(config.Config.SaveIPNum)
+	err = updateWebHosts(s, state)
+	if err != nil {
+		fmt.Fprintln(config.InfoOutput, err)
+	}
+	publishCloudflare(signalCtx, s)
+	publishRFC2136(signalCtx, s)
+	exportProxyConfigs(s, state)
+	exportUpstreams(s, state)
+	exportTemplates(s, state, start)
+	return false
+}
+
+// 守护模式：按计划重复测速，结果和黑白名单在轮次之间常驻内存
+func runDaemon(ctx context.Context, state *runState) {
+	schedule, err := utils.NewSchedule(config.Config.DaemonCron, config.Config.DaemonInterval)
+	if err != nil {
+		fmt.Fprintln(config.InfoOutput, err)
+		return
+	}
+	state.schedule = schedule
+	for {
+		if runCycle(ctx, state) {
+			return
+		}
+		next := schedule.Next(time.Now())
+		fmt.Fprintf(config.InfoOutput, "[信息] 下一次测速时间: %s\n", next.Format("2006-01-02 15:04:05"))
+		// 等待期间监控当前 IP
+		waitCtx, cancel := context.WithDeadline(ctx, next)
+		runMonitor(waitCtx, state)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+	}
 }
 
 func main() {
-	fmt.Printf("# etherwave/CloudflareSpeedTest %s \n\n", config.Version)
 	err := config.Init()
+	fmt.Fprintf(config.InfoOutput, "# etherwave/CloudflareSpeedTest %s \n\n", config.Version) // 在 Init 之后输出，-format 非 table 时输出到 stderr
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(config.InfoOutput, err)
 		return
 	}
 	if config.ShowStatus {
@@ -114,33 +325,71 @@ func main() {
 		)
 		return
 	}
+	if isHistoryQuery() {
+		runHistoryQuery()
+		return
+	}
+	if config.Report {
+		runReport()
+		return
+	}
+	if config.RemoveHosts {
+		err := hostsFile().RemoveBlock()
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+		}
+		return
+	}
+	if config.Rollback {
+		backupFile, err := hostsFile().Rollback()
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			return
+		}
+		if config.DryRun {
+			fmt.Fprintf(config.InfoOutput, "[dry-run] 将从 %s 恢复 hosts 文件，未写入\n", backupFile)
+			return
+		}
+		fmt.Fprintf(config.InfoOutput, "已从 %s 恢复 hosts 文件\n", backupFile)
+		return
+	}
 	if config.UpdateIPByIndex > -1 {
 		lastSpeedResultSlice := speedTest.NewSpeedResultSlice(nil)
 		lastSpeedResultSlice.LoadSpeedResultSlice(config.Config.OutputFile)
 		if config.UpdateIPByIndex > len(*lastSpeedResultSlice) {
-			fmt.Printf("UpdateIPByIndex %d > len(%d)\n", config.UpdateIPByIndex, len(*lastSpeedResultSlice))
+			fmt.Fprintf(config.InfoOutput, "UpdateIPByIndex %d > len(%d)\n", config.UpdateIPByIndex, len(*lastSpeedResultSlice))
 			return
 		}
 		indexIp := (*lastSpeedResultSlice)[config.UpdateIPByIndex].IP.String()
-		err := utils.UpdateHosts(indexIp, config.Config.WebHosts) // 更新hosts文件
+		err := applyHostsIP(indexIp, &runState{}) // 更新hosts文件
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(config.InfoOutput, err)
 			return
 		}
 		return
 	}
-	s := SpeedTest() // 获取下载测速结果
-	fmt.Println("SpeedTest Done")
-	// s.Print(config.Config.TestIPNum)
-	s.Print(10)
-	err = outputResultAllowDenayIPV4(s)
-	if err != nil {
-		fmt.Println(err)
+	// Ctrl-C / SIGTERM 时取消正在进行的测速，保存已完成的结果后退出
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-signalCtx.Done()
+		stop() // 恢复默认处理，再次 Ctrl-C 时直接退出
+	}()
+	state := loadRunState()
+	if config.Config.DNSServerAddr != "" && (config.Monitor || config.Daemon) {
+		startDNSServer(signalCtx, state)
+	}
+	if config.Monitor {
+		if config.Config.MonitorInterval <= 0 {
+			fmt.Fprintln(config.InfoOutput, "监控模式需要配置 MonitorInterval")
+			return
+		}
+		runMonitor(signalCtx, state)
 		return
 	}
-	err = updateWebHosts(s)
-	if err != nil {
-		fmt.Println(err)
+	if config.Daemon {
+		runDaemon(signalCtx, state)
 		return
 	}
+	runCycle(signalCtx, state)
 }
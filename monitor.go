@@ -0,0 +1,152 @@
+package main
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/speedTest"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 按测速模式测试单个 IP，不显示进度条
+func probeIP(ctx context.Context, mode string, sr *speedTest.SpeedResult) {
+	switch mode {
+	case "tls":
+		sr.TlsTest(ctx, config.Config.TcpPort, config.Config.TLSServerName, config.Config.TcpConnectTimes, config.Config.TcpConnectTimeout, nil)
+	case "http":
+		sr.HttpTest(
+			ctx,
+			config.Config.HttpColo,
+			&config.Config.HttpColoSet,
+			config.Config.HttpConnectTimes,
+			config.Config.HttpConnectTimeout,
+			config.Config.HttpStatusCode,
+			config.Config.HttpURL,
+			config.Config.HttpTCPPort,
+			nil,
+		)
+	case "trace":
+		sr.TraceTest(
+			ctx,
+			config.Config.HttpColo,
+			&config.Config.HttpColoSet,
+			config.Config.HttpConnectTimes,
+			config.Config.HttpConnectTimeout,
+			config.Config.TraceURL,
+			config.Config.HttpTCPPort,
+			nil,
+		)
+	default:
+		sr.TcpTest(ctx, config.Config.TcpPort, config.Config.TcpConnectTimes, config.Config.TcpConnectTimeout, nil)
+	}
+}
+
+func monitorThreshold() speedTest.Threshold {
+	return speedTest.Threshold{
+		MaxDelay:    config.Config.MonitorMaxDelay,
+		MaxLossRate: config.Config.MonitorMaxLossRate,
+	}
+}
+
+// 测试 IP 是否健康，返回测试结果
+func checkIP(ctx context.Context, ip string) (*speedTest.SpeedResult, bool) {
+	ipAddr, err := net.ResolveIPAddr("ip", ip)
+	if err != nil {
+		return nil, false
+	}
+	sr := &(*speedTest.NewSpeedResultSlice([]*net.IPAddr{ipAddr}))[0]
+	probeIP(ctx, config.Config.TestMode, sr)
+	return sr, monitorThreshold().Pass(sr)
+}
+
+// 追加一条监控事件到 MonitorEventFile
+func recordMonitorEvent(event string, fromIP string, toIP string, sr *speedTest.SpeedResult) {
+	if config.Config.MonitorEventFile == "" {
+		return
+	}
+	_, statErr := os.Stat(config.Config.MonitorEventFile)
+	fp, err := os.OpenFile(config.Config.MonitorEventFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "打开文件[%s]失败：%v\n", config.Config.MonitorEventFile, err)
+		return
+	}
+	defer fp.Close()
+	w := csv.NewWriter(fp)
+	if os.IsNotExist(statErr) {
+		_ = w.Write([]string{"time", "event", "from_ip", "to_ip", "delay_ms", "loss_rate"})
+	}
+	delay, lossRate := "", ""
+	if sr != nil && sr.Tested() {
+		lossRate = strconv.FormatFloat(float64(sr.Sended-sr.Received)/float64(sr.Sended), 'f', 2, 64)
+		if sr.Received > 0 {
+			delay = strconv.FormatFloat(sr.Delay.Seconds()*1000, 'f', 2, 64)
+		}
+	}
+	_ = w.Write([]string{time.Now().Format(time.RFC3339), event, fromIP, toIP, delay, lossRate})
+	w.Flush()
+}
+
+// 检查当前写入 hosts 的 IP，不健康时切换到上次结果中的下一个可用 IP
+func checkAppliedIP(ctx context.Context, state *runState) {
+	currentIP := state.appliedIP
+	if currentIP == "" {
+		ip, err := hostsFile().GetIP(config.Config.WebHosts)
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			return
+		}
+		currentIP = ip
+	}
+	sr, ok := checkIP(ctx, currentIP)
+	if ok || ctx.Err() != nil {
+		return
+	}
+	fmt.Fprintf(config.InfoOutput, "[信息] 当前 IP %s 不满足监控条件，尝试切换\n", currentIP)
+	recordMonitorEvent("degraded", currentIP, "", sr)
+	for i := 0; i < len(*state.last); i++ {
+		candidate := (*state.last)[i].IP.String()
+		if candidate == currentIP {
+			continue
+		}
+		csr, ok := checkIP(ctx, candidate)
+		if ctx.Err() != nil {
+			return
+		}
+		if !ok {
+			continue
+		}
+		err := applyHostsIP(candidate, state) // 更新hosts文件
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			return
+		}
+		state.appliedIP = candidate
+		fmt.Fprintf(config.InfoOutput, "[信息] 已切换到 %s\n", candidate)
+		recordMonitorEvent("failover", currentIP, candidate, csr)
+		return
+	}
+	fmt.Fprintln(config.InfoOutput, "[信息] 上次结果中没有可用的 IP，保持不变")
+	recordMonitorEvent("failover_failed", currentIP, "", sr)
+}
+
+// 每隔 MonitorInterval 检查一次当前 IP，直到 ctx 结束；未配置间隔时直接等待 ctx 结束
+func runMonitor(ctx context.Context, state *runState) {
+	if config.Config.MonitorInterval <= 0 {
+		<-ctx.Done()
+		return
+	}
+	ticker := time.NewTicker(config.Config.MonitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkAppliedIP(ctx, state)
+		}
+	}
+}
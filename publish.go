@@ -0,0 +1,74 @@
+package main
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/publisher"
+	"CloudflareSpeedTest/speedTest"
+	"context"
+	"fmt"
+)
+
+// 记录类型对应的地址族
+func recordFamily(recordType string) string {
+	if recordType == "AAAA" {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// 把排名靠前的 IP 发布到 Cloudflare DNS，记录没有变化时不修改
+func publishCloudflare(ctx context.Context, s *speedTest.SpeedResultSlice) {
+	if config.Config.CloudflareZoneID == "" {
+		return
+	}
+	cf := publisher.NewCloudflare(config.Config.CloudflareAPIURL, config.Config.CloudflareAPIToken, config.Config.CloudflareZoneID)
+	cf.DryRun = config.DryRun
+	for _, record := range config.Config.CloudflareRecords {
+		count := record.Count
+		if count <= 0 {
+			count = 1
+		}
+		ips := s.TopIPs(recordFamily(record.Type), count)
+		if len(ips) == 0 {
+			fmt.Fprintf(config.InfoOutput, "[信息] 没有可用于 %s %s 记录的 IP，跳过\n", record.Name, record.Type)
+			continue
+		}
+		changed, err := cf.Publish(ctx, record.Name, record.Type, ips, record.TTL, record.Proxied)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "更新 Cloudflare 记录 %s %s 失败：%v\n", record.Name, record.Type, err)
+			continue
+		}
+		if changed {
+			fmt.Fprintf(config.InfoOutput, "[信息] 已将 Cloudflare 记录 %s %s 更新为 %v\n", record.Name, record.Type, ips)
+		}
+	}
+}
+
+// 通过 RFC 2136 UPDATE 把排名靠前的 IP 发布到各自的服务器，记录没有变化时不发送
+func publishRFC2136(ctx context.Context, s *speedTest.SpeedResultSlice) {
+	for _, record := range config.Config.RFC2136Records {
+		u, err := publisher.NewRFC2136(record.Server, record.Zone, record.KeyName, record.KeyAlgorithm, record.KeySecret)
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			continue
+		}
+		u.DryRun = config.DryRun
+		count := record.Count
+		if count <= 0 {
+			count = 1
+		}
+		ips := s.TopIPs(recordFamily(record.Type), count)
+		if len(ips) == 0 {
+			fmt.Fprintf(config.InfoOutput, "[信息] 没有可用于 %s %s 记录的 IP，跳过\n", record.Name, record.Type)
+			continue
+		}
+		changed, err := u.Publish(ctx, record.Name, record.Type, ips, record.TTL)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "通过 %s 更新记录 %s %s 失败：%v\n", record.Server, record.Name, record.Type, err)
+			continue
+		}
+		if changed {
+			fmt.Fprintf(config.InfoOutput, "[信息] 已通过 %s 将记录 %s %s 更新为 %v\n", record.Server, record.Name, record.Type, ips)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package publisher
+
+import (
+	"CloudflareSpeedTest/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Cloudflare 通过 Cloudflare v4 API 更新 DNS 记录，DryRun 时只输出将要进行的修改
+type Cloudflare struct {
+	BaseURL string // 如 https://api.cloudflare.com/client/v4
+	Token   string
+	ZoneID  string
+	DryRun  bool
+	client  *http.Client
+}
+
+func NewCloudflare(baseURL, token, zoneID string) *Cloudflare {
+	return &Cloudflare{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		ZoneID:  zoneID,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// DNS 记录，TTL 为 1 表示自动
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (c *Cloudflare) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var r cloudflareResponse
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return fmt.Errorf("%s %s: HTTP %d，无法解析响应：%v", method, path, resp.StatusCode, err)
+	}
+	if !r.Success {
+		var messages []string
+		for _, e := range r.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("%s %s: HTTP %d %s", method, path, resp.StatusCode, strings.Join(messages, "; "))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+func (c *Cloudflare) listRecords(ctx context.Context, name, recordType string) ([]cloudflareRecord, error) {
+	query := url.Values{"name": {name}, "type": {recordType}, "per_page": {"100"}}
+	var records []cloudflareRecord
+	err := c.do(ctx, http.MethodGet, "/zones/"+c.ZoneID+"/dns_records?"+query.Encode(), nil, &records)
+	return records, err
+}
+
+// Publish 让 name 的 recordType 记录恰好指向 ips：内容已存在的记录保留，
+// 多余的记录改为缺少的 IP，仍不够时新建，剩下的删除；返回是否有修改
+func (c *Cloudflare) Publish(ctx context.Context, name, recordType string, ips []string, ttl int, proxied bool) (bool, error) {
+	if ttl <= 0 || proxied { // 代理的记录 Cloudflare 总是返回 1（自动）
+		ttl = 1
+	}
+	existing, err := c.listRecords(ctx, name, recordType)
+	if err != nil {
+		return false, err
+	}
+	wanted := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		wanted[ip] = true
+	}
+	var keep, spare []cloudflareRecord
+	for _, record := range existing {
+		if wanted[record.Content] {
+			delete(wanted, record.Content) // 同一 IP 的重复记录视为多余
+			keep = append(keep, record)
+		} else {
+			spare = append(spare, record)
+		}
+	}
+	changed := false
+	path := "/zones/" + c.ZoneID + "/dns_records"
+	for _, record := range keep {
+		if record.TTL == ttl && record.Proxied == proxied {
+			continue
+		}
+		record.TTL, record.Proxied = ttl, proxied
+		err = c.apply(ctx, http.MethodPut, path+"/"+record.ID, record)
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	for _, ip := range ips {
+		if !wanted[ip] {
+			continue
+		}
+		delete(wanted, ip)
+		record := cloudflareRecord{Type: recordType, Name: name, Content: ip, TTL: ttl, Proxied: proxied}
+		if len(spare) > 0 {
+			record.ID = spare[0].ID
+			spare = spare[1:]
+			err = c.apply(ctx, http.MethodPut, path+"/"+record.ID, record)
+		} else {
+			err = c.apply(ctx, http.MethodPost, path, record)
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	for _, record := range spare {
+		err = c.apply(ctx, http.MethodDelete, path+"/"+record.ID, record)
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// 执行一次修改，DryRun 时只输出
+func (c *Cloudflare) apply(ctx context.Context, method, path string, record cloudflareRecord) error {
+	if c.DryRun {
+		fmt.Fprintf(config.InfoOutput, "[dry-run] Cloudflare %s %s %s %s\n", method, record.Type, record.Name, record.Content)
+		return nil
+	}
+	var body interface{} = record
+	if method == http.MethodDelete {
+		body = nil
+	}
+	return c.do(ctx, method, path, body, nil)
+}
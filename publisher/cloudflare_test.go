@@ -0,0 +1,195 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 模拟 Cloudflare DNS 记录 API，记录收到的修改请求
+type mockCloudflare struct {
+	mu       sync.Mutex
+	records  map[string]cloudflareRecord
+	nextID   int
+	requests []string
+	fail     bool // 所有请求都返回 API 错误
+}
+
+func (m *mockCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" || m.fail {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`)
+		return
+	}
+	const prefix = "/zones/zone/dns_records"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	var result interface{}
+	switch r.Method {
+	case http.MethodGet:
+		list := []cloudflareRecord{}
+		for _, record := range m.records {
+			if record.Name == r.URL.Query().Get("name") && record.Type == r.URL.Query().Get("type") {
+				list = append(list, record)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		result = list
+	case http.MethodPost, http.MethodPut:
+		var record cloudflareRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		if r.Method == http.MethodPost {
+			m.nextID++
+			id = fmt.Sprintf("id%d", m.nextID)
+		}
+		if record.Proxied {
+			record.TTL = 1
+		}
+		record.ID = id
+		m.records[id] = record
+		result = record
+	case http.MethodDelete:
+		delete(m.records, id)
+	}
+	m.requests = append(m.requests, r.Method+" "+id)
+	data, _ := json.Marshal(result)
+	fmt.Fprintf(w, `{"success":true,"errors":[],"result":%s}`, data)
+}
+
+// 返回 GET 以外的请求并清空
+func (m *mockCloudflare) changes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var changes []string
+	for _, request := range m.requests {
+		if !strings.HasPrefix(request, http.MethodGet) {
+			changes = append(changes, request)
+		}
+	}
+	m.requests = nil
+	return changes
+}
+
+func (m *mockCloudflare) contents() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var contents []string
+	for _, record := range m.records {
+		contents = append(contents, fmt.Sprintf("%s/%d/%v", record.Content, record.TTL, record.Proxied))
+	}
+	sort.Strings(contents)
+	return contents
+}
+
+func TestCloudflarePublish(t *testing.T) {
+	mock := &mockCloudflare{records: map[string]cloudflareRecord{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	c := NewCloudflare(server.URL+"/", "token", "zone")
+	ctx := context.Background()
+
+	steps := []struct {
+		name     string
+		ips      []string
+		ttl      int
+		proxied  bool
+		changed  bool
+		changes  []string
+		contents []string
+	}{
+		{"新建", []string{"1.1.1.1", "1.0.0.1"}, 60, false, true,
+			[]string{"POST id1", "POST id2"}, []string{"1.0.0.1/60/false", "1.1.1.1/60/false"}},
+		{"没有变化时跳过", []string{"1.0.0.1", "1.1.1.1"}, 60, false, false, nil,
+			[]string{"1.0.0.1/60/false", "1.1.1.1/60/false"}},
+		{"修改多余的记录", []string{"1.1.1.1", "1.0.0.2"}, 60, false, true,
+			[]string{"PUT id2"}, []string{"1.0.0.2/60/false", "1.1.1.1/60/false"}},
+		{"修改 TTL", []string{"1.1.1.1", "1.0.0.2"}, 120, false, true,
+			[]string{"PUT id1", "PUT id2"}, []string{"1.0.0.2/120/false", "1.1.1.1/120/false"}},
+		{"删除多余的记录", []string{"1.1.1.1"}, 120, false, true,
+			[]string{"DELETE id2"}, []string{"1.1.1.1/120/false"}},
+		{"开启代理", []string{"1.1.1.1"}, 120, true, true,
+			[]string{"PUT id1"}, []string{"1.1.1.1/1/true"}},
+		{"代理的记录忽略 TTL", []string{"1.1.1.1"}, 120, true, false, nil, []string{"1.1.1.1/1/true"}},
+	}
+	for _, step := range steps {
+		changed, err := c.Publish(ctx, "cdn.example.com", "A", step.ips, step.ttl, step.proxied)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if changed != step.changed {
+			t.Errorf("%s: changed = %v, want %v", step.name, changed, step.changed)
+		}
+		if got := mock.changes(); fmt.Sprint(got) != fmt.Sprint(step.changes) {
+			t.Errorf("%s: 请求 %v, want %v", step.name, got, step.changes)
+		}
+		if got := mock.contents(); fmt.Sprint(got) != fmt.Sprint(step.contents) {
+			t.Errorf("%s: 记录 %v, want %v", step.name, got, step.contents)
+		}
+	}
+
+	// 其他类型的记录不受影响
+	if _, err := c.Publish(ctx, "cdn.example.com", "AAAA", []string{"2606:4700::1"}, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := mock.contents(); fmt.Sprint(got) != "[1.1.1.1/1/true 2606:4700::1/1/false]" {
+		t.Errorf("记录 %v", got)
+	}
+}
+
+func TestCloudflareDryRun(t *testing.T) {
+	mock := &mockCloudflare{records: map[string]cloudflareRecord{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	c := NewCloudflare(server.URL, "token", "zone")
+	c.DryRun = true
+	changed, err := c.Publish(context.Background(), "cdn.example.com", "A", []string{"1.1.1.1"}, 0, false)
+	if err != nil || !changed {
+		t.Fatalf("changed = %v, err = %v", changed, err)
+	}
+	if got := mock.changes(); len(got) != 0 || len(mock.contents()) != 0 {
+		t.Errorf("dry-run 不应修改记录：%v", got)
+	}
+}
+
+func TestCloudflareAPIError(t *testing.T) {
+	mock := &mockCloudflare{records: map[string]cloudflareRecord{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	_, err := NewCloudflare(server.URL, "bad", "zone").Publish(context.Background(), "cdn.example.com", "A", []string{"1.1.1.1"}, 0, false)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 403") || !strings.Contains(err.Error(), "10000 Authentication error") {
+		t.Errorf("err = %v", err)
+	}
+
+	// 已有记录时 API 返回错误
+	c := NewCloudflare(server.URL, "token", "zone")
+	if _, err := c.Publish(context.Background(), "cdn.example.com", "A", []string{"1.1.1.1"}, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	mock.fail = true
+	if _, err := c.Publish(context.Background(), "cdn.example.com", "A", []string{"1.0.0.1"}, 0, false); err == nil {
+		t.Errorf("API 返回错误时应返回错误")
+	}
+
+	// 响应不是 JSON
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "<html>502</html>")
+	}))
+	defer bad.Close()
+	_, err = NewCloudflare(bad.URL, "token", "zone").Publish(context.Background(), "cdn.example.com", "A", []string{"1.1.1.1"}, 0, false)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 502") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package publisher
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/dns"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RFC2136 通过 TSIG 签名的 RFC 2136 UPDATE 报文更新记录，DryRun 时只输出将要进行的修改
+type RFC2136 struct {
+	Server string // 主服务器地址，如 10.0.0.53:53
+	Zone   string
+	Key    *dns.TSIGKey // 为 nil 时不签名
+	DryRun bool
+}
+
+func NewRFC2136(server, zone, keyName, keyAlgorithm, keySecret string) (*RFC2136, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	u := &RFC2136{Server: server, Zone: strings.TrimSuffix(zone, ".")}
+	if keyName != "" {
+		secret, err := base64.StdEncoding.DecodeString(keySecret)
+		if err != nil {
+			return nil, fmt.Errorf("TSIG 密钥 %s 不是有效的 base64：%v", keyName, err)
+		}
+		u.Key = &dns.TSIGKey{Name: strings.TrimSuffix(keyName, "."), Algorithm: keyAlgorithm, Secret: secret}
+	}
+	return u, nil
+}
+
+func recordTypeCode(recordType string) (uint16, error) {
+	switch recordType {
+	case "A":
+		return dns.TypeA, nil
+	case "AAAA":
+		return dns.TypeAAAA, nil
+	}
+	return 0, fmt.Errorf("不支持的记录类型 [%s]", recordType)
+}
+
+// 查询服务器上 name 当前的记录，返回排序后的 IP 和 TTL
+func (u *RFC2136) current(ctx context.Context, name string, rrType uint16) ([]string, uint32, error) {
+	query := &dns.Message{ID: uint16(rand.UintN(1 << 16)), Question: []dns.Question{{Name: name, Type: rrType, Class: dns.ClassINET}}}
+	r, err := dns.Exchange(ctx, "udp", u.Server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.Rcode() != dns.RcodeSuccess && r.Rcode() != dns.RcodeNXDomain {
+		return nil, 0, fmt.Errorf("查询 %s 失败，响应码 %d", name, r.Rcode())
+	}
+	var ips []string
+	var ttl uint32
+	for _, rr := range r.Answer {
+		if rr.Type == rrType && strings.EqualFold(rr.Name, name) {
+			ips = append(ips, net.IP(rr.Data).String())
+			ttl = rr.TTL
+		}
+	}
+	sort.Strings(ips)
+	return ips, ttl, nil
+}
+
+// 未配置 TTL 时使用的 TTL，与内置 DNS 服务器的默认值一致
+const defaultRFC2136TTL = 60
+
+// Publish 用 ips 替换 name 的 recordType 记录，服务器上的记录已一致时不发送 UPDATE；返回是否有修改
+func (u *RFC2136) Publish(ctx context.Context, name, recordType string, ips []string, ttl uint32) (bool, error) {
+	name = strings.TrimSuffix(name, ".")
+	if ttl == 0 { // TTL 为 0 的记录不能被缓存
+		ttl = defaultRFC2136TTL
+	}
+	rrType, err := recordTypeCode(recordType)
+	if err != nil {
+		return false, err
+	}
+	wanted := append([]string(nil), ips...)
+	sort.Strings(wanted)
+	current, currentTTL, err := u.current(ctx, name, rrType)
+	if err != nil {
+		return false, err
+	}
+	if strings.Join(current, ",") == strings.Join(wanted, ",") && currentTTL == ttl {
+		return false, nil
+	}
+	if u.DryRun {
+		fmt.Fprintf(config.InfoOutput, "[dry-run] RFC 2136 %s %s %s: %v -> %v\n", u.Server, name, recordType, current, wanted)
+		return true, nil
+	}
+	// Zone 段为区域的 SOA，Update 段先删除整个 RRset 再逐条添加
+	m := &dns.Message{
+		ID:        uint16(rand.UintN(1 << 16)),
+		Question:  []dns.Question{{Name: u.Zone, Type: dns.TypeSOA, Class: dns.ClassINET}},
+		Authority: []dns.RR{{Name: name, Type: rrType, Class: dns.ClassANY}},
+	}
+	m.SetOpcode(dns.OpcodeUpdate)
+	for _, ip := range ips {
+		data := net.ParseIP(ip)
+		if rrType == dns.TypeA {
+			data = data.To4()
+		}
+		if data == nil {
+			return false, fmt.Errorf("IP [%s] 与记录类型 %s 不符", ip, recordType)
+		}
+		m.Authority = append(m.Authority, dns.RR{Name: name, Type: rrType, Class: dns.ClassINET, TTL: ttl, Data: data})
+	}
+	err = u.send(ctx, m)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 签名后通过 TCP 发送 UPDATE 报文并检查响应
+func (u *RFC2136) send(ctx context.Context, m *dns.Message) error {
+	var req, requestMAC []byte
+	var err error
+	if u.Key != nil {
+		req, requestMAC, err = m.PackSigned(u.Key, time.Now())
+	} else {
+		req, err = m.Pack()
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := dns.ExchangeRaw(ctx, "tcp", u.Server, req)
+	if err != nil {
+		return err
+	}
+	r, err := dns.Unpack(resp)
+	if err != nil {
+		return err
+	}
+	if r.ID != m.ID {
+		return fmt.Errorf("UPDATE 响应 ID 不匹配")
+	}
+	if r.Rcode() != dns.RcodeSuccess {
+		return fmt.Errorf("UPDATE 被拒绝，响应码 %d", r.Rcode())
+	}
+	if u.Key != nil {
+		err = u.Key.VerifyResponse(resp, requestMAC)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package speedTest
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WithBudget 为一个测速阶段设置时间预算，budget <= 0 时不限制
+func WithBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, budget)
+}
+
+// Interrupted 是否被用户中断（Ctrl-C / SIGTERM），到达截止时间不算中断
+func Interrupted(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
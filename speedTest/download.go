@@ -52,6 +52,7 @@ func printDownloadDebugInfo(ip *net.IPAddr, err error, statusCode int, url, last
 
 // return download Speed
 func downloadURLByIP(
+	ctx context.Context,
 	downloadTimeout time.Duration,
 	downloadURL string,
 	ip *net.IPAddr,
@@ -74,7 +75,7 @@ func downloadURLByIP(
 			return nil
 		},
 	}
-	req, err := http.NewRequest("GET", downloadURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
 	if err != nil {
 		if config.Debug { // 调试模式下，输出更多信息
 			utils.Red.Printf("[调试] IP: %s, 下载测速请求创建失败，错误信息: %v, 下载测速地址: %s\n", ip.String(), err, downloadURL)
@@ -116,16 +117,19 @@ func downloadURLByIP(
 }
 
 func (s *SpeedResult) DownloadTest(
+	ctx context.Context,
 	downloadTestTimes int,
 	downloadTimeOut time.Duration,
 	downloadURL string,
 	downloadTCPPort int,
 	bar *utils.Bar) {
 	defer bar.Grow(1, "")
-	s.DownloadSpeed = 0
 	var totalSpeed float64 = 0
 	for i := 0; i < downloadTestTimes; i++ {
-		speed, colo := downloadURLByIP(downloadTimeOut, downloadURL, s.IP, downloadTCPPort)
+		speed, colo := downloadURLByIP(ctx, downloadTimeOut, downloadURL, s.IP, downloadTCPPort)
+		if ctx.Err() != nil { // 被中断，保留原有的下载速度
+			return
+		}
 		totalSpeed += speed
 		if s.Colo == "" { // 只有当 Colo 是空的时候，才写入，否则代表之前是 httping 测速并获取过了
 			s.Colo = colo
@@ -135,17 +139,23 @@ func (s *SpeedResult) DownloadTest(
 }
 
 func (s *SpeedResultSlice) DownloadTest(
+	ctx context.Context,
 	downloadTestIPNum int,
 	downloadIPTestTimes int,
 	downloadTimeout time.Duration,
 	downloadURL string,
-	downloadTCPPort int) {
+	downloadTCPPort int,
+	earlyStop EarlyStop) {
+	stopper := earlyStop.start(ctx)
+	defer stopper.done()
+	ctx = stopper.ctx
 	bar := utils.NewBar(downloadTestIPNum, "", "")
 	if downloadTestIPNum > len(*s) {
 		downloadTestIPNum = len(*s)
 	}
-	for i := 0; i < downloadTestIPNum; i++ {
-		(*s)[i].DownloadTest(downloadIPTestTimes, downloadTimeout, downloadURL, downloadTCPPort, bar)
+	for i := 0; i < downloadTestIPNum && ctx.Err() == nil; i++ {
+		(*s)[i].DownloadTest(ctx, downloadIPTestTimes, downloadTimeout, downloadURL, downloadTCPPort, bar)
+		stopper.check(&(*s)[i])
 	}
 	bar.Done()
 }
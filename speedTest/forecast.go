@@ -0,0 +1,51 @@
+package speedTest
+
+import (
+	"sort"
+	"time"
+)
+
+// Forecast 一个 IP 在某个时间窗口内的预期表现，来自历史上同一时段的测量
+type Forecast struct {
+	Delay         time.Duration
+	LossRate      float32
+	DownloadSpeed float64 // 0 表示没有该时段的下载速度
+	Samples       int
+}
+
+// SortByForecast 本轮测试成功且有预测的 IP 用预测值排序，其余 IP 用本轮的值；
+// 本轮失败的 IP 仍排在后面。bySpeed 为 true 时先按下载速度排序
+func (s *SpeedResultSlice) SortByForecast(forecasts map[string]Forecast, bySpeed bool) {
+	keys := make([]Forecast, len(*s))
+	for i := range *s {
+		sr := &(*s)[i]
+		keys[i] = Forecast{Delay: sr.Delay, LossRate: sr.getLossRate(), DownloadSpeed: sr.DownloadSpeed}
+		f, ok := forecasts[sr.IP.String()]
+		if !ok || !sr.Tested() || sr.Received == 0 {
+			continue
+		}
+		keys[i].Delay, keys[i].LossRate = f.Delay, f.LossRate
+		if f.DownloadSpeed > 0 {
+			keys[i].DownloadSpeed = f.DownloadSpeed
+		}
+	}
+	index := make([]int, len(*s))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool {
+		a, b := &keys[index[i]], &keys[index[j]]
+		if bySpeed && a.DownloadSpeed != b.DownloadSpeed {
+			return a.DownloadSpeed > b.DownloadSpeed
+		}
+		if a.Delay != b.Delay {
+			return a.Delay < b.Delay
+		}
+		return a.LossRate < b.LossRate
+	})
+	sorted := make(SpeedResultSlice, len(*s))
+	for i, j := range index {
+		sorted[i] = (*s)[j]
+	}
+	*s = sorted
+}
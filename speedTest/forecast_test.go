@@ -0,0 +1,47 @@
+package speedTest
+
+import (
+	"CloudflareSpeedTest/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortByForecast(t *testing.T) {
+	forecasts := map[string]Forecast{
+		"1.1.1.2": {Delay: 50 * time.Millisecond, Samples: 3}, // 这个时段通常更快，没有速度预测
+		"1.1.1.3": {Delay: 10 * time.Millisecond, DownloadSpeed: 99, Samples: 3},
+		"1.1.1.4": {Delay: 200 * time.Millisecond, DownloadSpeed: 50, Samples: 3},
+	}
+	tests := []struct {
+		name    string
+		bySpeed bool
+		want    []string
+	}{
+		{"按延迟", false, []string{"1.1.1.2", "1.1.1.1", "1.1.1.4", "1.1.1.3"}},
+		{"按下载速度", true, []string{"1.1.1.4", "1.1.1.2", "1.1.1.1", "1.1.1.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := SpeedResultSlice{
+				newResult("1.1.1.1", 4, 4, 100*time.Millisecond, 10),
+				newResult("1.1.1.2", 4, 4, 150*time.Millisecond, 30),
+				newResult("1.1.1.3", 4, 0, config.MaxDelay, 0), // 本轮失败，有再好的预测也排在后面
+				newResult("1.1.1.4", 4, 4, 120*time.Millisecond, 20),
+			}
+			s.SortByForecast(forecasts, tt.bySpeed)
+			got := make([]string, len(s))
+			for i := range s {
+				got[i] = s[i].IP.String()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("顺序 = %v，应为 %v", got, tt.want)
+			}
+			for i := range s {
+				if s[i].IP.String() == "1.1.1.2" && (s[i].Delay != 150*time.Millisecond || s[i].DownloadSpeed != 30) {
+					t.Errorf("排序不应修改本轮的测量值: %+v", s[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package speedTest
+
+import (
+	"CloudflareSpeedTest/config"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Threshold 筛选条件，零值字段表示不限制
+type Threshold struct {
+	MaxDelay    time.Duration
+	MaxLossRate float32
+	MinSpeed    float64 // 字节/秒
+}
+
+// Pass 是否完成测试且满足全部条件
+func (t Threshold) Pass(s *SpeedResult) bool {
+	if !s.Tested() || s.Received == 0 || s.Delay >= config.MaxDelay {
+		return false
+	}
+	if t.MaxDelay > 0 && s.Delay > t.MaxDelay {
+		return false
+	}
+	if t.MaxLossRate > 0 && s.getLossRate() > t.MaxLossRate {
+		return false
+	}
+	if t.MinSpeed > 0 && s.DownloadSpeed < t.MinSpeed {
+		return false
+	}
+	return true
+}
+
+// Funnel 将满足条件的结果保持原有顺序移到前面，其余的依次排在后面，
+// 返回进入下一阶段的数量（不超过 limit，limit <= 0 表示全部）
+func (s *SpeedResultSlice) Funnel(threshold Threshold, limit int) int {
+	passed := make(SpeedResultSlice, 0, len(*s))
+	failed := make(SpeedResultSlice, 0)
+	for i := 0; i < len(*s); i++ {
+		if threshold.Pass(&(*s)[i]) {
+			passed = append(passed, (*s)[i])
+		} else {
+			failed = append(failed, (*s)[i])
+		}
+	}
+	n := copy(*s, passed)
+	copy((*s)[n:], failed)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	return n
+}
+
+// EarlyStop 找到 Count 个满足 Threshold 的 IP 后提前结束测试，Count <= 0 表示全部测试
+type EarlyStop struct {
+	Count     int
+	Threshold Threshold
+}
+
+type earlyStopper struct {
+	EarlyStop
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	passed int32
+}
+
+// 返回的 ctx 在满足条件的 IP 数量达到 Count 后被取消
+func (e EarlyStop) start(ctx context.Context) *earlyStopper {
+	stopCtx, cancel := context.WithCancel(ctx)
+	return &earlyStopper{EarlyStop: e, parent: ctx, ctx: stopCtx, cancel: cancel}
+}
+
+// 每个 IP 测试完成后调用
+func (e *earlyStopper) check(s *SpeedResult) {
+	if e.Count <= 0 || !e.Threshold.Pass(s) {
+		return
+	}
+	if atomic.AddInt32(&e.passed, 1) == int32(e.Count) {
+		e.cancel()
+	}
+}
+
+func (e *earlyStopper) done() {
+	if e.ctx.Err() != nil && e.parent.Err() == nil {
+		fmt.Fprintf(config.InfoOutput, "[信息] 已找到 %d 个满足条件的 IP，提前结束测试\n", e.Count)
+	}
+	e.cancel()
+}
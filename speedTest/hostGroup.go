@@ -0,0 +1,145 @@
+package speedTest
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/utils"
+	"hash/fnv"
+	"strings"
+)
+
+func familyMatches(s *SpeedResult, family string) bool {
+	switch family {
+	case "ipv4":
+		return s.IP.IP.To4() != nil
+	case "ipv6":
+		return s.IP.IP.To4() == nil
+	}
+	return true
+}
+
+// 测试成功且地区码、地址族都符合
+func eligible(sr *SpeedResult, colos config.StrSet, family string) bool {
+	if !(Threshold{}).Pass(sr) || !familyMatches(sr, family) {
+		return false
+	}
+	return len(colos) == 0 || colos.Contains(sr.Colo)
+}
+
+// candidates 按排名顺序返回测试成功、地区码和地址族都符合的前 topN 个结果，topN <= 0 表示全部
+func (s *SpeedResultSlice) candidates(colos config.StrSet, family string, topN int) []*SpeedResult {
+	var result []*SpeedResult
+	for i := 0; i < len(*s) && (topN <= 0 || len(result) < topN); i++ {
+		sr := &(*s)[i]
+		if eligible(sr, colos, family) {
+			result = append(result, sr)
+		}
+	}
+	return result
+}
+
+// 域名的 FNV 哈希，hash 策略据此选择，候选不变时每个域名总是分到同一名次的 IP
+func hostHash(host string) int {
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+// GroupSwitch 域名组的切换策略，与 ChooseIP 相同：上次的 IP 仍满足 Keep 且新 IP 没有好出 Margin 时保留，
+// round-robin 和 hash 策略下上次的 IP 仍在候选中且满足 Keep 时保留
+type GroupSwitch struct {
+	Previous []utils.HostsEntry // 该组上次写入的记录
+	Margin   float64
+	Keep     Threshold
+}
+
+// 上次为 host 写入的、地址族符合的 IP
+func (g GroupSwitch) previousIP(host string, family string) string {
+	for _, entry := range g.Previous {
+		if entry.Host != host {
+			continue
+		}
+		if family == "any" || family == "" || (family == "ipv4") == utils.IsIPv4(entry.IP) {
+			return entry.IP
+		}
+	}
+	return ""
+}
+
+// 按策略为每个域名选择一个 IP：best 全部使用第一个，round-robin 依次轮流，hash 按域名哈希分配
+func (s *SpeedResultSlice) assignHostsIP(group config.HostGroupConfig, family string, candidates []*SpeedResult, sw GroupSwitch) []utils.HostsEntry {
+	var entries []utils.HostsEntry
+	for i, host := range group.Hosts {
+		host = strings.ToLower(host)
+		var sr *SpeedResult
+		switch group.Strategy {
+		case "round-robin":
+			sr = candidates[i%len(candidates)]
+		case "hash", "random": // random 为旧名称，同样按哈希分配
+			sr = candidates[hostHash(host)%len(candidates)]
+		default:
+			sr = candidates[0]
+		}
+		if j := s.Index(sw.previousIP(host, family)); j >= 0 && sr.IP.String() != (*s)[j].IP.String() {
+			current := &(*s)[j]
+			keep := eligible(current, group.Colos, family) && sw.Keep.Pass(current)
+			if group.Strategy == "round-robin" || group.Strategy == "hash" || group.Strategy == "random" {
+				keep = keep && containsResult(candidates, current)
+			} else {
+				keep = keep && sw.Margin > 0 && !sr.betterThan(current, sw.Margin)
+			}
+			if keep {
+				sr = current
+			}
+		}
+		entries = append(entries, utils.NewHostsEntries([]string{sr.IP.String()}, []string{host})...)
+	}
+	return entries
+}
+
+func containsResult(results []*SpeedResult, sr *SpeedResult) bool {
+	for _, r := range results {
+		if r == sr {
+			return true
+		}
+	}
+	return false
+}
+
+// HostGroupEntries 按域名组的地区码、地址族和选择策略生成 hosts 记录，没有符合条件的 IP 时返回 nil。
+// Family 为 dual 时每个域名分别写入一个 IPv4 和一个 IPv6
+func (s *SpeedResultSlice) HostGroupEntries(group config.HostGroupConfig, sw GroupSwitch) []utils.HostsEntry {
+	topN := group.TopN
+	if group.Strategy == "" || group.Strategy == "best" {
+		topN = 1
+	}
+	if group.Family != "dual" {
+		candidates := s.candidates(group.Colos, group.Family, topN)
+		if len(candidates) == 0 {
+			return nil
+		}
+		return s.assignHostsIP(group, group.Family, candidates, sw)
+	}
+	var entries []utils.HostsEntry
+	for _, family := range []string{"ipv4", "ipv6"} {
+		candidates := s.candidates(group.Colos, family, topN)
+		if len(candidates) == 0 {
+			continue
+		}
+		entries = append(entries, s.assignHostsIP(group, family, candidates, sw)...)
+	}
+	return entries
+}
+
+// Top 按排名顺序返回前 n 个测试成功且地址族符合的结果
+func (s *SpeedResultSlice) Top(family string, n int) []*SpeedResult {
+	return s.candidates(nil, family, n)
+}
+
+// TopIPs 按排名顺序返回前 n 个测试成功且地址族符合的 IP
+func (s *SpeedResultSlice) TopIPs(family string, n int) []string {
+	var ips []string
+	for _, sr := range s.Top(family, n) {
+		ips = append(ips, sr.IP.String())
+	}
+	return ips
+}
@@ -5,6 +5,7 @@ import (
 
 	"CloudflareSpeedTest/config"
 	"CloudflareSpeedTest/utils"
+	"context"
 	"io"
 	"log"
 	"net/http"
@@ -91,6 +92,7 @@ func filterColo(colo string, coloMap *config.StrSet) string {
 }
 
 func (s *SpeedResult) HttpTest(
+	ctx context.Context,
 	httpColo string,
 	httpColoSet *config.StrSet,
 	httpConnectTimes int,
@@ -102,7 +104,11 @@ func (s *SpeedResult) HttpTest(
 	defer bar.Grow(1, "")
 	s.Sended = httpConnectTimes
 	s.Received = 0
+	s.timeouts = 0
 	s.Delay = config.MaxDelay
+	s.LossRate = 0
+	s.Mode = "http"
+	s.TestedAt = time.Now()
 	s.Colo = ""
 	hc := http.Client{
 		Timeout: httpConnectTimeout,
@@ -118,7 +124,7 @@ func (s *SpeedResult) HttpTest(
 	// 先访问一次获得 HTTP 状态码 及 地区码
 	var colo string
 	{
-		request, err := http.NewRequest(http.MethodHead, httpUrl, nil)
+		request, err := http.NewRequestWithContext(ctx, http.MethodHead, httpUrl, nil)
 		if err != nil {
 			if config.Debug { // 调试模式下，输出更多信息
 				utils.Red.Printf("[调试] IP: %s, 延迟测速请求创建失败，错误信息: %v, 测速地址: %s\n", s.IP.String(), err, httpUrl)
@@ -126,8 +132,19 @@ func (s *SpeedResult) HttpTest(
 			return
 		}
 		request.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.80 Safari/537.36")
+		if err := utils.WaitProbe(ctx); err != nil { // 被中断，本次结果不可信
+			s.resetUntested()
+			return
+		}
 		response, err := hc.Do(request)
 		if err != nil {
+			if ctx.Err() != nil { // 被中断，本次结果不可信
+				s.resetUntested()
+				return
+			}
+			if utils.IsTimeout(err) {
+				s.timeouts = httpConnectTimes // 后面的探测不再进行，全部按超时计算
+			}
 			if config.Debug { // 调试模式下，输出更多信息
 				utils.Red.Printf("[调试] IP: %s, 延迟测速失败，错误信息: %v, 测速地址: %s\n", s.IP.String(), err, httpUrl)
 			}
@@ -176,7 +193,7 @@ func (s *SpeedResult) HttpTest(
 	s.Received = 0
 	var delay time.Duration
 	for i := 0; i < httpConnectTimes; i++ {
-		request, err := http.NewRequest(http.MethodHead, httpUrl, nil)
+		request, err := http.NewRequestWithContext(ctx, http.MethodHead, httpUrl, nil)
 		if err != nil {
 			log.Fatal("http.NewRequest error: ", err)
 			return
@@ -185,9 +202,20 @@ func (s *SpeedResult) HttpTest(
 		if i == httpConnectTimes-1 {
 			request.Header.Set("Connection", "close")
 		}
+		if err := utils.WaitProbe(ctx); err != nil { // 被中断，本次结果不可信
+			s.resetUntested()
+			return
+		}
 		startTime := time.Now()
 		response, err := hc.Do(request)
 		if err != nil {
+			if ctx.Err() != nil {
+				s.resetUntested()
+				return
+			}
+			if utils.IsTimeout(err) {
+				s.timeouts++
+			}
 			continue
 		}
 		s.Received++
@@ -203,20 +231,28 @@ func (s *SpeedResult) HttpTest(
 }
 
 func (s *SpeedResultSlice) HttpTest(
+	ctx context.Context,
 	httpColo string,
 	httpColoSet config.StrSet,
 	httpConnectTimes int,
 	httpConnectTimeout time.Duration,
 	httpRoutines int,
+	poolOptions utils.WorkerPoolOptions,
+	earlyStop EarlyStop,
 	httpStatusCode int,
 	httpURL string,
 	httpTCPPort int) {
-	workerPool := utils.NewWorkerPool(httpRoutines)
+	stopper := earlyStop.start(ctx)
+	defer stopper.done()
+	ctx = stopper.ctx
+	workerPool := utils.NewWorkerPoolWithOptions(ctx, httpRoutines, poolOptions)
+	probeCtx := workerPool.ProbeContext(ctx) // 每次探测前按 ProbeRateLimit 等待
 	bar := utils.NewBar(len(*s), "", "")
 	for i := 0; i < len(*s); i++ {
 		sr := &((*s)[i])
-		workerPool.Submit(func() {
+		err := workerPool.Submit(func() {
 			sr.HttpTest(
+				probeCtx,
 				httpColo,
 				&httpColoSet,
 				httpConnectTimes,
@@ -226,7 +262,14 @@ func (s *SpeedResultSlice) HttpTest(
 				httpTCPPort,
 				bar,
 			)
+			stopper.check(sr)
+			if sr.Tested() {
+				workerPool.Report(sr.timeouts, sr.Sended)
+			}
 		})
+		if err != nil { // 已中断，剩余 IP 不再测试
+			break
+		}
 	}
 	workerPool.Wait()
 	bar.Done()
@@ -0,0 +1,118 @@
+package speedTest
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JSONResult JSON / NDJSON 输出中的一条结果，数值字段不做格式化
+type JSONResult struct {
+	IP            string    `json:"ip"`
+	Sent          int       `json:"sent"`
+	Received      int       `json:"received"`
+	LossRate      float32   `json:"loss_rate"` // 0-1
+	DelayMs       float64   `json:"delay_ms"`
+	DownloadSpeed float64   `json:"download_speed"` // 字节/秒
+	Colo          string    `json:"colo,omitempty"`
+	Mode          string    `json:"mode,omitempty"`
+	TestedAt      time.Time `json:"tested_at,omitempty"`
+}
+
+func (s *SpeedResult) JSONResult() JSONResult {
+	return JSONResult{
+		IP:            s.IP.String(),
+		Sent:          s.Sended,
+		Received:      s.Received,
+		LossRate:      s.getLossRate(),
+		DelayMs:       float64(s.Delay.Microseconds()) / 1000,
+		DownloadSpeed: s.DownloadSpeed,
+		Colo:          s.Colo,
+		Mode:          s.Mode,
+		TestedAt:      s.TestedAt,
+	}
+}
+
+// SpeedResult 转换回测速结果，IP 无效时返回错误
+func (r *JSONResult) SpeedResult() (SpeedResult, error) {
+	ip := net.ParseIP(r.IP)
+	if ip == nil {
+		return SpeedResult{}, fmt.Errorf("无效的 IP [%s]", r.IP)
+	}
+	return SpeedResult{
+		IP:            &net.IPAddr{IP: ip},
+		Sended:        r.Sent,
+		Received:      r.Received,
+		LossRate:      r.LossRate,
+		Delay:         time.Duration(r.DelayMs * float64(time.Millisecond)),
+		DownloadSpeed: r.DownloadSpeed,
+		Colo:          r.Colo,
+		Mode:          r.Mode,
+		TestedAt:      r.TestedAt,
+	}, nil
+}
+
+// 按格式写出结果：json 为数组，ndjson 每行一条，csv 与结果文件相同
+func (s *SpeedResultSlice) writeFormat(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		results := make([]JSONResult, 0, len(*s))
+		for i := 0; i < len(*s); i++ {
+			results = append(results, (*s)[i].JSONResult())
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for i := 0; i < len(*s); i++ {
+			err := enc.Encode((*s)[i].JSONResult())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return utils.WriteResultsCSV(w, s.toRecords())
+	}
+	return fmt.Errorf("不支持的输出格式 [%s]，可选 csv / json / ndjson", format)
+}
+
+// SaveFormats 把前 num 个测试成功的结果另外保存为 json / ndjson，
+// 文件名为 outputFile 换成对应的扩展名，csv 由 SaveSpeedResultSlice 保存
+func (s *SpeedResultSlice) SaveFormats(outputFile string, num int, formats []string) {
+	ws := s.Best(num)
+	base := strings.TrimSuffix(outputFile, filepath.Ext(outputFile))
+	for _, format := range formats {
+		if format == "csv" {
+			continue
+		}
+		var b bytes.Buffer
+		err := ws.writeFormat(&b, format)
+		if err != nil {
+			fmt.Fprintln(config.InfoOutput, err)
+			continue
+		}
+		path := base + "." + format
+		err = utils.WriteFileAtomic(path, b.Bytes(), 0644)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "写入文件[%s]失败：%v\n", path, err)
+		}
+	}
+}
+
+// 以 config.PrintFormat 输出前 num 个结果到 config.ResultOutput，table 以外的格式使用
+func (s *SpeedResultSlice) printFormat(num int) {
+	ws := (*s)[:num]
+	err := ws.writeFormat(config.ResultOutput, config.PrintFormat)
+	if err != nil {
+		fmt.Fprintln(config.InfoOutput, err)
+	}
+}
@@ -0,0 +1,114 @@
+package speedTest
+
+import (
+	"math"
+	"time"
+)
+
+// MergeOptions 本轮测量与历史测量合并的参数
+type MergeOptions struct {
+	HalfLife      time.Duration // 测量值的权重每经过 HalfLife 减半，<= 0 时不合并
+	MaxAge        time.Duration // 超过 MaxAge 的历史测量丢弃，0 表示不过期
+	MinConfidence float64       // 本轮没有测下载速度时，历史速度的置信度不低于该值才使用
+}
+
+// 合并前本轮的原始测量值，写入历史时使用
+type measurement struct {
+	Delay         time.Duration
+	LossRate      float32
+	DownloadSpeed float64
+}
+
+// 历史测量的权重，过期时返回 0
+func (o MergeOptions) weight(age time.Duration) float64 {
+	if o.MaxAge > 0 && age > o.MaxAge {
+		return 0
+	}
+	if age < 0 {
+		age = 0
+	}
+	return math.Pow(0.5, float64(age)/float64(o.HalfLife))
+}
+
+// 权重之和 w 对应的置信度 w/(w+1)：只有一次测量时为 0.5，历史越多越新越接近 1
+func confidence(w float64) float64 {
+	return w / (w + 1)
+}
+
+// Measurement 本轮的原始测量结果，合并过历史时返回合并前的值
+func (s *SpeedResult) Measurement() JSONResult {
+	r := s.JSONResult()
+	if s.measured != nil {
+		r.DelayMs = float64(s.measured.Delay.Microseconds()) / 1000
+		r.LossRate = s.measured.LossRate
+		r.DownloadSpeed = s.measured.DownloadSpeed
+	}
+	return r
+}
+
+// MergeLatency 把本轮测试成功的 IP 的延迟和丢包率与历史按时间衰减加权平均，
+// 本轮失败的 IP 保持失败；history 以 IP 为键
+func (s *SpeedResultSlice) MergeLatency(history map[string][]JSONResult, opts MergeOptions, now time.Time) {
+	if opts.HalfLife <= 0 {
+		return
+	}
+	for i := 0; i < len(*s); i++ {
+		sr := &(*s)[i]
+		if !sr.Tested() {
+			continue
+		}
+		sr.measured = &measurement{Delay: sr.Delay, LossRate: sr.getLossRate()}
+		lossSum, lossWeight := float64(sr.measured.LossRate), 1.0
+		delaySum, delayWeight := float64(sr.Delay), 1.0
+		for _, r := range history[sr.IP.String()] {
+			w := opts.weight(now.Sub(r.TestedAt))
+			if w == 0 {
+				continue
+			}
+			lossSum += w * float64(r.LossRate)
+			lossWeight += w
+			if r.Received > 0 {
+				delaySum += w * r.DelayMs * float64(time.Millisecond)
+				delayWeight += w
+			}
+		}
+		sr.LossRate = float32(lossSum / lossWeight)
+		if sr.Received > 0 {
+			sr.Delay = time.Duration(delaySum / delayWeight)
+		}
+	}
+}
+
+// MergeSpeed 在下载测速之后调用：本轮测了速度的 IP 与历史速度加权平均，
+// 没有测的 IP 只在历史速度的置信度达到 MinConfidence 时使用历史速度
+func (s *SpeedResultSlice) MergeSpeed(history map[string][]JSONResult, opts MergeOptions, now time.Time) {
+	if opts.HalfLife <= 0 {
+		return
+	}
+	for i := 0; i < len(*s); i++ {
+		sr := &(*s)[i]
+		if !sr.Tested() || sr.Received == 0 {
+			continue
+		}
+		if sr.measured == nil {
+			sr.measured = &measurement{Delay: sr.Delay, LossRate: sr.getLossRate()}
+		}
+		sr.measured.DownloadSpeed = sr.DownloadSpeed
+		var sum, weight float64
+		if sr.DownloadSpeed > 0 {
+			sum, weight = sr.DownloadSpeed, 1
+		}
+		for _, r := range history[sr.IP.String()] {
+			w := opts.weight(now.Sub(r.TestedAt))
+			if w == 0 || r.DownloadSpeed <= 0 {
+				continue
+			}
+			sum += w * r.DownloadSpeed
+			weight += w
+		}
+		if weight == 0 || (sr.DownloadSpeed <= 0 && confidence(weight) < opts.MinConfidence) {
+			continue
+		}
+		sr.DownloadSpeed = sum / weight
+	}
+}
@@ -0,0 +1,145 @@
+package speedTest
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func newResult(ip string, sent, received int, delay time.Duration, speed float64) SpeedResult {
+	return SpeedResult{
+		IP:            &net.IPAddr{IP: net.ParseIP(ip)},
+		Sended:        sent,
+		Received:      received,
+		Delay:         delay,
+		DownloadSpeed: speed,
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestMergeLatency(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	opts := MergeOptions{HalfLife: time.Hour, MaxAge: 24 * time.Hour}
+	history := map[string][]JSONResult{
+		"1.1.1.1": {
+			{IP: "1.1.1.1", Sent: 4, Received: 4, DelayMs: 400, LossRate: 0.5, TestedAt: now.Add(-time.Hour)},     // 权重 0.5
+			{IP: "1.1.1.1", Sent: 4, Received: 4, DelayMs: 5000, LossRate: 1, TestedAt: now.Add(-25 * time.Hour)}, // 已过期
+			{IP: "1.1.1.1", Sent: 4, Received: 0, LossRate: 1, TestedAt: now.Add(-time.Hour)},                     // 失败记录只计入丢包率
+		},
+		"1.0.0.1": {
+			{IP: "1.0.0.1", Sent: 4, Received: 4, DelayMs: 50, TestedAt: now},
+		},
+	}
+	s := SpeedResultSlice{
+		newResult("1.1.1.1", 4, 4, 100*time.Millisecond, 0),
+		newResult("1.0.0.1", 4, 0, 0, 0), // 本轮失败
+		newResult("1.0.0.2", 0, 0, 0, 0), // 未测试
+	}
+	s.MergeLatency(history, opts, now)
+
+	// 延迟：(100 + 0.5*400) / 1.5；丢包率：(0 + 0.5*0.5 + 0.5*1) / 2
+	if got := s[0].Delay; got != 200*time.Millisecond {
+		t.Errorf("合并后延迟 = %v，应为 200ms", got)
+	}
+	if got := s[0].LossRate; !almostEqual(float64(got), 0.375) {
+		t.Errorf("合并后丢包率 = %v，应为 0.375", got)
+	}
+	if s[1].Received != 0 || s[1].Delay != 0 {
+		t.Errorf("本轮失败的 IP 应保持失败: Received = %d, Delay = %v", s[1].Received, s[1].Delay)
+	}
+	if s[2].Tested() || s[2].measured != nil {
+		t.Error("未测试的 IP 不应合并")
+	}
+
+	m := s[0].Measurement()
+	if m.DelayMs != 100 || m.LossRate != 0 {
+		t.Errorf("Measurement() = %+v，应为合并前的值", m)
+	}
+	if j := s[0].JSONResult(); j.DelayMs != 200 {
+		t.Errorf("JSONResult().DelayMs = %v，应为合并后的 200", j.DelayMs)
+	}
+}
+
+func TestMergeLatencyDisabled(t *testing.T) {
+	now := time.Now()
+	history := map[string][]JSONResult{
+		"1.1.1.1": {{IP: "1.1.1.1", Sent: 4, Received: 4, DelayMs: 400, TestedAt: now}},
+	}
+	s := SpeedResultSlice{newResult("1.1.1.1", 4, 4, 100*time.Millisecond, 0)}
+	s.MergeLatency(history, MergeOptions{}, now)
+	if s[0].Delay != 100*time.Millisecond || s[0].measured != nil {
+		t.Errorf("HalfLife 为 0 时不应合并: %v", s[0].Delay)
+	}
+}
+
+func TestMergeSpeed(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		speed         float64
+		history       []JSONResult
+		minConfidence float64
+		want          float64
+	}{
+		{
+			name:    "本轮测了速度时与历史加权平均",
+			speed:   10,
+			history: []JSONResult{{DownloadSpeed: 40, TestedAt: now.Add(-time.Hour)}},
+			want:    20, // (10 + 0.5*40) / 1.5
+		},
+		{
+			name:  "过期和没有速度的历史记录忽略",
+			speed: 10,
+			history: []JSONResult{
+				{DownloadSpeed: 1000, TestedAt: now.Add(-25 * time.Hour)},
+				{DownloadSpeed: 0, TestedAt: now},
+			},
+			want: 10,
+		},
+		{
+			name:          "本轮没测速度，历史置信度不足时不使用",
+			history:       []JSONResult{{DownloadSpeed: 40, TestedAt: now}}, // 权重 1，置信度 0.5
+			minConfidence: 0.6,
+			want:          0,
+		},
+		{
+			name: "本轮没测速度，历史置信度足够时使用",
+			history: []JSONResult{
+				{DownloadSpeed: 40, TestedAt: now},
+				{DownloadSpeed: 10, TestedAt: now},
+			}, // 权重 2，置信度 0.67
+			minConfidence: 0.6,
+			want:          25,
+		},
+	}
+	opts := MergeOptions{HalfLife: time.Hour, MaxAge: 24 * time.Hour}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := SpeedResultSlice{newResult("1.1.1.1", 4, 4, 100*time.Millisecond, tt.speed)}
+			opts.MinConfidence = tt.minConfidence
+			s.MergeSpeed(map[string][]JSONResult{"1.1.1.1": tt.history}, opts, now)
+			if got := s[0].DownloadSpeed; !almostEqual(got, tt.want) {
+				t.Errorf("合并后速度 = %v，应为 %v", got, tt.want)
+			}
+			if got := s[0].Measurement().DownloadSpeed; got != tt.speed {
+				t.Errorf("Measurement().DownloadSpeed = %v，应为本轮的 %v", got, tt.speed)
+			}
+		})
+	}
+}
+
+func TestMergeSpeedSkipsFailed(t *testing.T) {
+	now := time.Now()
+	history := map[string][]JSONResult{
+		"1.1.1.1": {{DownloadSpeed: 40, TestedAt: now}, {DownloadSpeed: 40, TestedAt: now}},
+	}
+	s := SpeedResultSlice{newResult("1.1.1.1", 4, 0, 0, 0)}
+	s.MergeSpeed(history, MergeOptions{HalfLife: time.Hour}, now)
+	if s[0].DownloadSpeed != 0 || s[0].measured != nil {
+		t.Errorf("本轮失败的 IP 不应使用历史速度: %v", s[0].DownloadSpeed)
+	}
+}
@@ -2,7 +2,7 @@ package speedTest
 
 import (
 	"CloudflareSpeedTest/config"
-	"encoding/csv"
+	"CloudflareSpeedTest/utils"
 	"fmt"
 	"net"
 	"os"
@@ -47,6 +47,10 @@ type SpeedResult struct {
 	Colo          string
 	LossRate      float32
 	DownloadSpeed float64
+	Mode          string    // 延迟测试方式
+	TestedAt      time.Time // 延迟测试时间
+	measured      *measurement
+	timeouts      int // 本轮超时的探测次数，用于自适应并发
 }
 
 func (s *SpeedResult) getLossRate() float32 {
@@ -60,6 +64,27 @@ func (s *SpeedResult) getLossRate() float32 {
 	return s.LossRate
 }
 
+// GetLossRate 丢包率，未测试时为 1
+func (s *SpeedResult) GetLossRate() float32 {
+	return s.getLossRate()
+}
+
+// Tested 本次是否完成了延迟测试，Sended 为 0 代表未测试或测试被中断
+func (s *SpeedResult) Tested() bool {
+	return s.Sended > 0
+}
+
+// 测试被中断时恢复为未测试状态，避免被当作不可用 IP 写入黑名单
+func (s *SpeedResult) resetUntested() {
+	s.Sended = 0
+	s.Received = 0
+	s.Delay = config.MaxDelay
+	s.LossRate = 0
+	s.Mode = ""
+	s.TestedAt = time.Time{}
+	s.measured = nil
+}
+
 func (s *SpeedResult) toStringSlice() []string {
 	result := make([]string, 7)
 	result[0] = s.IP.String()
@@ -75,19 +100,58 @@ func (s *SpeedResult) toStringSlice() []string {
 	return result
 }
 
-func (s *SpeedResult) fromStringSlice(data []string) error {
-	if len(data) != 7 {
-		return fmt.Errorf("数据格式错误")
+// 结果文件中的一行，列顺序与 utils.ResultColumns 相同
+func (s *SpeedResult) toRecord() []string {
+	testedAt := ""
+	if !s.TestedAt.IsZero() {
+		testedAt = s.TestedAt.Format(time.RFC3339Nano)
+	}
+	return []string{
+		s.IP.String(),
+		strconv.Itoa(s.Sended),
+		strconv.Itoa(s.Received),
+		strconv.FormatFloat(float64(s.getLossRate()), 'f', 4, 32),
+		strconv.FormatFloat(float64(s.Delay.Microseconds())/1000, 'f', 3, 64),
+		strconv.FormatFloat(s.DownloadSpeed, 'f', 0, 64),
+		s.Colo,
+		s.Mode,
+		testedAt,
 	}
-	s.IP, _ = net.ResolveIPAddr("ip", data[0])
-	s.Sended, _ = strconv.Atoi(data[1])
-	s.Received, _ = strconv.Atoi(data[2])
-	_lossRate, _ := strconv.ParseFloat(data[3], 64)
-	s.LossRate = float32(_lossRate)
-	s.Delay, _ = time.ParseDuration(data[4] + "ms")
-	s.DownloadSpeed, _ = strconv.ParseFloat(data[5], 64)
-	s.Colo = data[6]
-	return nil
+}
+
+// 从结果文件的一行解析，缺少的列保持零值，存在但无法解析的列返回错误
+func (s *SpeedResult) fromRecord(record utils.ResultRecord) error {
+	ip := net.ParseIP(record["ip"])
+	if ip == nil {
+		return fmt.Errorf("无效的 IP [%s]", record["ip"])
+	}
+	s.IP = &net.IPAddr{IP: ip}
+	var err error
+	parse := func(name string, f func(string) error) {
+		if err != nil || record[name] == "" {
+			return
+		}
+		if e := f(record[name]); e != nil {
+			err = fmt.Errorf("%s 列的值 [%s] 无效", name, record[name])
+		}
+	}
+	parse("sent", func(v string) (e error) { s.Sended, e = strconv.Atoi(v); return })
+	parse("received", func(v string) (e error) { s.Received, e = strconv.Atoi(v); return })
+	parse("loss_rate", func(v string) error {
+		lossRate, e := strconv.ParseFloat(v, 32)
+		s.LossRate = float32(lossRate)
+		return e
+	})
+	parse("delay_ms", func(v string) error {
+		delay, e := strconv.ParseFloat(v, 64)
+		s.Delay = time.Duration(delay * float64(time.Millisecond))
+		return e
+	})
+	parse("download_speed", func(v string) (e error) { s.DownloadSpeed, e = strconv.ParseFloat(v, 64); return })
+	parse("tested_at", func(v string) (e error) { s.TestedAt, e = time.Parse(time.RFC3339Nano, v); return })
+	s.Colo = record["colo"]
+	s.Mode = record["mode"]
+	return err
 }
 
 func (s *SpeedResult) less(other *SpeedResult) bool {
@@ -109,7 +173,7 @@ func NewSpeedResultSlice(ips []*net.IPAddr) *SpeedResultSlice {
 			IP:            ips[i],
 			Sended:        0,
 			Received:      0,
-			Delay:         0,
+			Delay:         config.MaxDelay, // 未测试的 IP 排在最后
 			Colo:          "",
 			LossRate:      0,
 			DownloadSpeed: 0,
@@ -118,29 +182,14 @@ func NewSpeedResultSlice(ips []*net.IPAddr) *SpeedResultSlice {
 	return s
 }
 
-func (s *SpeedResultSlice) toStringSlice() [][]string {
+func (s *SpeedResultSlice) toRecords() [][]string {
 	var result [][]string
 	for i := 0; i < len(*s); i++ {
-		result = append(result, (*s)[i].toStringSlice())
+		result = append(result, (*s)[i].toRecord())
 	}
 	return result
 }
 
-func (s *SpeedResultSlice) fromStringSlice(data [][]string) error {
-	if len(data) == 0 {
-		return fmt.Errorf("数据格式错误")
-	}
-	for i := 0; i < len(data); i++ {
-		speedResult := SpeedResult{}
-		err := speedResult.fromStringSlice(data[i])
-		if err != nil {
-			return err
-		}
-		*s = append(*s, speedResult)
-	}
-	return nil
-}
-
 func CopyFileSmall(src, dst string) error {
 	data, err := os.ReadFile(src)
 	if err != nil {
@@ -150,51 +199,71 @@ func CopyFileSmall(src, dst string) error {
 	return os.WriteFile(dst, data, 0644)
 }
 
+// Best 前 num 个结果中测试成功的部分，即保存到结果文件中的内容
+func (s *SpeedResultSlice) Best(num int) *SpeedResultSlice {
+	ws := NewSpeedResultSlice(nil)
+	for i := 0; i < len(*s) && i < num; i++ {
+		if (*s)[i].getLossRate() == 1.0 || (*s)[i].Delay == config.MaxDelay {
+			continue
+		}
+		*ws = append(*ws, (*s)[i])
+	}
+	return ws
+}
+
+// IPV4Uint32s 结果中的 IPv4 地址
+func (s *SpeedResultSlice) IPV4Uint32s() *[]uint32 {
+	ips := make([]uint32, 0, len(*s))
+	for i := 0; i < len(*s); i++ {
+		if (*s)[i].IP == nil || (*s)[i].IP.IP.To4() == nil {
+			continue
+		}
+		ips = append(ips, utils.NetIPAddrIPV4toUint32((*s)[i].IP))
+	}
+	return &ips
+}
+
 func (s *SpeedResultSlice) SaveSpeedResultSlice(outputFile string, num int) {
 	if len(*s) == 0 {
 		return
 	}
 	fp, err := os.Create(outputFile)
 	if err != nil {
-		fmt.Printf("创建文件[%s]失败：%v", outputFile, err)
+		fmt.Fprintf(config.InfoOutput, "创建文件[%s]失败：%v", outputFile, err)
 		return
 	}
 	defer fp.Close()
-	ws := NewSpeedResultSlice(nil)
-	for i := 0; i < len(*s) && i < num; i++ {
-		if (*s)[i].getLossRate() == 1.0 || (*s)[i].Delay == config.MaxDelay {
-			continue
-		}
-		*ws = append(*ws, (*s)[i])
+	ws := s.Best(num)
+	err = utils.WriteResultsCSV(fp, ws.toRecords())
+	if err != nil {
+		fmt.Fprintf(config.InfoOutput, "写入文件[%s]失败：%v", outputFile, err)
+		return
 	}
-	var lines [][]string
-	lines = append(lines, []string{"IP 地址", "已发送", "已接收", "丢包率", "平均延迟", "下载速度(MB/s)", "地区码"})
-	lines = append(lines, ws.toStringSlice()...)
-	w := csv.NewWriter(fp) //创建一个新的写入文件流
-	_ = w.WriteAll(lines)
-	w.Flush()
 	// copy file to file with date
 	outputFileWithDate := time.Now().Format("2006-01-02") + "_" + outputFile
 	err = CopyFileSmall(outputFile, outputFileWithDate)
 	if err != nil {
-		fmt.Printf("复制文件[%s]失败：%v", outputFile, err)
+		fmt.Fprintf(config.InfoOutput, "复制文件[%s]失败：%v", outputFile, err)
 		return
 	}
 }
 
+// LoadSpeedResultSlice 读取任意版本的结果文件，无法解析的行跳过并提示
 func (s *SpeedResultSlice) LoadSpeedResultSlice(inputFile string) error {
-	fp, err := os.Open(inputFile)
+	_, records, err := utils.ReadResultsCSV(inputFile)
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
-	r := csv.NewReader(fp)
-	lines, err := r.ReadAll()
-	if err != nil {
-		return err
+	for i, record := range records {
+		speedResult := SpeedResult{}
+		err := speedResult.fromRecord(record)
+		if err != nil {
+			fmt.Fprintf(config.InfoOutput, "[信息] 跳过结果文件[%s]第 %d 条记录：%v\n", inputFile, i+1, err)
+			continue
+		}
+		*s = append(*s, speedResult)
 	}
-	err = s.fromStringSlice(lines[1:])
-	return err
+	return nil
 }
 
 // func (s *SpeedResultSlice) SortByDelay() {
@@ -239,12 +308,16 @@ func (s *SpeedResultSlice) Print(num int) {
 		return
 	}
 	if len(*s) <= 0 { // IP数组长度(IP数量) 大于 0 时继续
-		fmt.Println("\n[信息] 完整测速结果 IP 数量为 0, 跳过输出结果。")
+		fmt.Fprintln(config.InfoOutput, "\n[信息] 完整测速结果 IP 数量为 0, 跳过输出结果。")
 		return
 	}
 	if len(*s) < num { // 如果IP数组长度(IP数量) 小于  打印次数，则次数改为IP数量
 		num = len(*s)
 	}
+	if config.PrintFormat != "" && config.PrintFormat != "table" {
+		s.printFormat(num)
+		return
+	}
 	// fmt.Printf("\033[31m slice and first item address %p %p \033[0m\n", s, &(*s)[0])
 	dateString := make([][]string, 0)
 	for i := 0; i < num; i++ {
@@ -260,9 +333,9 @@ func (s *SpeedResultSlice) Print(num int) {
 			break
 		}
 	}
-	fmt.Printf(headFormat, "IP 地址", "已发送", "已接收", "丢包率", "平均延迟", "下载速度(MB/s)", "地区码")
+	fmt.Fprintf(config.InfoOutput, headFormat, "IP 地址", "已发送", "已接收", "丢包率", "平均延迟", "下载速度(MB/s)", "地区码")
 	for i := 0; i < num; i++ {
-		fmt.Printf(dataFormat, dateString[i][0], dateString[i][1], dateString[i][2], dateString[i][3], dateString[i][4], dateString[i][5], dateString[i][6])
+		fmt.Fprintf(config.InfoOutput, dataFormat, dateString[i][0], dateString[i][1], dateString[i][2], dateString[i][3], dateString[i][4], dateString[i][5], dateString[i][6])
 	}
 }
 
@@ -275,6 +348,6 @@ func ShowResultStatus(resultFile string) {
 			vaildIpNum++
 		}
 	}
-	fmt.Printf("vaildIpNum: %d\n", vaildIpNum)
-	fmt.Printf("totalIpNum: %d\n", len(speedResultSlice))
+	fmt.Fprintf(config.InfoOutput, "vaildIpNum: %d\n", vaildIpNum)
+	fmt.Fprintf(config.InfoOutput, "totalIpNum: %d\n", len(speedResultSlice))
 }
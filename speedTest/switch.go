@@ -0,0 +1,46 @@
+package speedTest
+
+// 是否比 other 好出 margin：下载速度都有时比较速度，只有一方有速度时（另一方下载测试失败）有速度的更好，
+// 都没有时比较延迟
+func (s *SpeedResult) betterThan(other *SpeedResult, margin float64) bool {
+	switch {
+	case s.DownloadSpeed > 0 && other.DownloadSpeed > 0:
+		return s.DownloadSpeed > other.DownloadSpeed*(1+margin)
+	case s.DownloadSpeed > 0:
+		return true
+	case other.DownloadSpeed > 0:
+		return false
+	}
+	return float64(s.Delay) < float64(other.Delay)*(1-margin)
+}
+
+// Index 返回 ip 在结果中的位置，不存在时返回 -1
+func (s *SpeedResultSlice) Index(ip string) int {
+	for i := 0; i < len(*s); i++ {
+		if (*s)[i].IP.String() == ip {
+			return i
+		}
+	}
+	return -1
+}
+
+// ChooseIP 切换策略：当前 IP 本次测试仍满足 keep，且排名第一的 IP 没有好出 margin 时保留当前 IP，
+// 避免两个相近的 IP 来回切换；margin <= 0 时总是选择排名第一的 IP
+func (s *SpeedResultSlice) ChooseIP(currentIP string, margin float64, keep Threshold) *SpeedResult {
+	if len(*s) == 0 {
+		return nil
+	}
+	best := &(*s)[0]
+	if currentIP == "" || margin <= 0 || best.IP.String() == currentIP {
+		return best
+	}
+	i := s.Index(currentIP)
+	if i < 0 || !keep.Pass(&(*s)[i]) { // 当前 IP 本次未测试或已劣化
+		return best
+	}
+	current := &(*s)[i]
+	if best.betterThan(current, margin) {
+		return best
+	}
+	return current
+}
@@ -3,12 +3,14 @@ package speedTest
 import (
 	"CloudflareSpeedTest/config"
 	"CloudflareSpeedTest/utils"
+	"context"
 	"fmt"
 	"net"
 	"time"
 )
 
 func (s *SpeedResult) TcpTest(
+	ctx context.Context,
 	tcpPort int,
 	tcpConnectTimes int,
 	tcpConnectTimeout time.Duration,
@@ -16,23 +18,39 @@ func (s *SpeedResult) TcpTest(
 	defer bar.Grow(1, "")
 	s.Sended = tcpConnectTimes
 	s.Received = 0
+	s.timeouts = 0
 	s.Delay = config.MaxDelay
+	s.LossRate = 0
+	s.Mode = "tcp"
+	s.TestedAt = time.Now()
+	var fullAddress string
+	if utils.IsIPv4(s.IP.String()) {
+		fullAddress = fmt.Sprintf("%s:%d", s.IP.String(), tcpPort)
+	} else {
+		fullAddress = fmt.Sprintf("[%s]:%d", s.IP.String(), tcpPort)
+	}
+	dialer := &net.Dialer{Timeout: tcpConnectTimeout}
 	var totalDelay time.Duration
 	for i := 0; i < tcpConnectTimes; i++ {
-		startTime := time.Now()
-		var fullAddress string
-		if utils.IsIPv4(s.IP.String()) {
-			fullAddress = fmt.Sprintf("%s:%d", s.IP.String(), tcpPort)
-		} else {
-			fullAddress = fmt.Sprintf("[%s]:%d", s.IP.String(), tcpPort)
+		if err := utils.WaitProbe(ctx); err != nil { // 被中断，本次结果不可信
+			s.resetUntested()
+			return
 		}
-		conn, err := net.DialTimeout("tcp", fullAddress, tcpConnectTimeout)
+		startTime := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", fullAddress)
 		if err != nil {
+			if ctx.Err() != nil { // 被中断，本次结果不可信
+				s.resetUntested()
+				return
+			}
+			if utils.IsTimeout(err) {
+				s.timeouts++
+			}
 			continue
 		}
-		defer conn.Close()
 		s.Received++
 		totalDelay += time.Since(startTime)
+		conn.Close()
 	}
 	if s.Received == 0 {
 		s.Delay = config.MaxDelay
@@ -41,14 +59,32 @@ func (s *SpeedResult) TcpTest(
 	}
 }
 
-func (s *SpeedResultSlice) TcpTest(routines int, tcpPort int, tcpConnectTimes int, tcpConnectTimeout time.Duration) {
-	workerPool := utils.NewWorkerPool(routines)
+func (s *SpeedResultSlice) TcpTest(
+	ctx context.Context,
+	routines int,
+	poolOptions utils.WorkerPoolOptions,
+	earlyStop EarlyStop,
+	tcpPort int,
+	tcpConnectTimes int,
+	tcpConnectTimeout time.Duration) {
+	stopper := earlyStop.start(ctx)
+	defer stopper.done()
+	ctx = stopper.ctx
+	workerPool := utils.NewWorkerPoolWithOptions(ctx, routines, poolOptions)
+	probeCtx := workerPool.ProbeContext(ctx) // 每次探测前按 ProbeRateLimit 等待
 	bar := utils.NewBar(len(*s), "", "")
 	for i := 0; i < len(*s); i++ {
 		sr := &((*s)[i])
-		workerPool.Submit(func() {
-			sr.TcpTest(tcpPort, tcpConnectTimes, tcpConnectTimeout, bar)
+		err := workerPool.Submit(func() {
+			sr.TcpTest(probeCtx, tcpPort, tcpConnectTimes, tcpConnectTimeout, bar)
+			stopper.check(sr)
+			if sr.Tested() {
+				workerPool.Report(sr.timeouts, sr.Sended)
+			}
 		})
+		if err != nil { // 已中断，剩余 IP 不再测试
+			break
+		}
 	}
 	workerPool.Wait()
 	bar.Done()
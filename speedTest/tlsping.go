@@ -0,0 +1,102 @@
+package speedTest
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/utils"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TlsTest 测量 TCP 连接 + TLS 握手的耗时，证书校验不通过视为丢包
+func (s *SpeedResult) TlsTest(
+	ctx context.Context,
+	tcpPort int,
+	serverName string,
+	tlsConnectTimes int,
+	tlsConnectTimeout time.Duration,
+	bar *utils.Bar) {
+	defer bar.Grow(1, "")
+	s.Sended = tlsConnectTimes
+	s.Received = 0
+	s.timeouts = 0
+	s.Delay = config.MaxDelay
+	s.LossRate = 0
+	s.Mode = "tls"
+	s.TestedAt = time.Now()
+	var fullAddress string
+	if utils.IsIPv4(s.IP.String()) {
+		fullAddress = fmt.Sprintf("%s:%d", s.IP.String(), tcpPort)
+	} else {
+		fullAddress = fmt.Sprintf("[%s]:%d", s.IP.String(), tcpPort)
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: tlsConnectTimeout},
+		Config:    &tls.Config{ServerName: serverName},
+	}
+	var totalDelay time.Duration
+	for i := 0; i < tlsConnectTimes; i++ {
+		if err := utils.WaitProbe(ctx); err != nil { // 被中断，本次结果不可信
+			s.resetUntested()
+			return
+		}
+		startTime := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", fullAddress)
+		if err != nil {
+			if ctx.Err() != nil { // 被中断，本次结果不可信
+				s.resetUntested()
+				return
+			}
+			if utils.IsTimeout(err) {
+				s.timeouts++
+			}
+			if config.Debug { // 调试模式下，输出更多信息
+				utils.Red.Printf("[调试] IP: %s, TLS 握手失败，错误信息: %v, SNI: %s\n", s.IP.String(), err, serverName)
+			}
+			continue
+		}
+		s.Received++
+		totalDelay += time.Since(startTime)
+		conn.Close()
+	}
+	if s.Received == 0 {
+		s.Delay = config.MaxDelay
+	} else {
+		s.Delay = totalDelay / time.Duration(s.Received)
+	}
+}
+
+func (s *SpeedResultSlice) TlsTest(
+	ctx context.Context,
+	routines int,
+	poolOptions utils.WorkerPoolOptions,
+	earlyStop EarlyStop,
+	tcpPort int,
+	serverName string,
+	tlsConnectTimes int,
+	tlsConnectTimeout time.Duration) {
+	stopper := earlyStop.start(ctx)
+	defer stopper.done()
+	ctx = stopper.ctx
+	workerPool := utils.NewWorkerPoolWithOptions(ctx, routines, poolOptions)
+	probeCtx := workerPool.ProbeContext(ctx) // 每次探测前按 ProbeRateLimit 等待
+	bar := utils.NewBar(len(*s), "", "")
+	for i := 0; i < len(*s); i++ {
+		sr := &((*s)[i])
+		err := workerPool.Submit(func() {
+			sr.TlsTest(probeCtx, tcpPort, serverName, tlsConnectTimes, tlsConnectTimeout, bar)
+			stopper.check(sr)
+			if sr.Tested() {
+				workerPool.Report(sr.timeouts, sr.Sended)
+			}
+		})
+		if err != nil { // 已中断，剩余 IP 不再测试
+			break
+		}
+	}
+	workerPool.Wait()
+	bar.Done()
+	workerPool.Stop()
+}
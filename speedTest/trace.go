@@ -0,0 +1,161 @@
+package speedTest
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/utils"
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 从 /cdn-cgi/trace 的响应中获取地区码
+// fl=123f45
+// h=cloudflare.com
+// ip=1.2.3.4
+// colo=SJC
+func getTraceColo(body io.Reader) string {
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if found && key == "colo" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// TraceTest 请求 Cloudflare 的 /cdn-cgi/trace，校验 IP 确实由 Cloudflare 提供服务并获取地区码
+func (s *SpeedResult) TraceTest(
+	ctx context.Context,
+	httpColo string,
+	httpColoSet *config.StrSet,
+	traceTimes int,
+	traceTimeout time.Duration,
+	traceURL string,
+	tcpPort int,
+	bar *utils.Bar) {
+	defer bar.Grow(1, "")
+	s.Sended = traceTimes
+	s.Received = 0
+	s.timeouts = 0
+	s.Delay = config.MaxDelay
+	s.LossRate = 0
+	s.Mode = "trace"
+	s.TestedAt = time.Now()
+	s.Colo = ""
+	hc := http.Client{
+		Timeout: traceTimeout,
+		Transport: &http.Transport{
+			DialContext: getDialContext(s.IP, tcpPort),
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // 阻止重定向
+		},
+	}
+	var delay time.Duration
+	for i := 0; i < traceTimes; i++ {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, traceURL, nil)
+		if err != nil {
+			if config.Debug { // 调试模式下，输出更多信息
+				utils.Red.Printf("[调试] IP: %s, trace 请求创建失败，错误信息: %v, 测速地址: %s\n", s.IP.String(), err, traceURL)
+			}
+			return
+		}
+		request.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.80 Safari/537.36")
+		if i == traceTimes-1 {
+			request.Header.Set("Connection", "close")
+		}
+		if err := utils.WaitProbe(ctx); err != nil { // 被中断，本次结果不可信
+			s.resetUntested()
+			return
+		}
+		startTime := time.Now()
+		response, err := hc.Do(request)
+		if err != nil {
+			if ctx.Err() != nil { // 被中断，本次结果不可信
+				s.resetUntested()
+				return
+			}
+			if utils.IsTimeout(err) {
+				s.timeouts++
+			}
+			continue
+		}
+		colo := ""
+		if response.StatusCode == http.StatusOK {
+			colo = getTraceColo(response.Body)
+		}
+		io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+		duration := time.Since(startTime)
+		if colo == "" { // 不是 Cloudflare 的 trace 响应
+			if config.Debug { // 调试模式下，输出更多信息
+				utils.Red.Printf("[调试] IP: %s, trace 响应无效，HTTP 状态码: %d, 测速地址: %s\n", s.IP.String(), response.StatusCode, traceURL)
+			}
+			continue
+		}
+		// 只有指定了地区才匹配机场地区码
+		if httpColo != "" && filterColo(colo, httpColoSet) == "" {
+			if config.Debug { // 调试模式下，输出更多信息
+				utils.Red.Printf("[调试] IP: %s, 地区码不匹配: %s\n", s.IP.String(), colo)
+			}
+			s.Received = 0
+			s.Delay = config.MaxDelay
+			return
+		}
+		s.Colo = colo
+		s.Received++
+		delay += duration
+	}
+	if s.Received == 0 {
+		return
+	}
+	s.Delay = delay / time.Duration(s.Received)
+}
+
+func (s *SpeedResultSlice) TraceTest(
+	ctx context.Context,
+	httpColo string,
+	httpColoSet config.StrSet,
+	traceTimes int,
+	traceTimeout time.Duration,
+	traceRoutines int,
+	poolOptions utils.WorkerPoolOptions,
+	earlyStop EarlyStop,
+	traceURL string,
+	traceTCPPort int) {
+	stopper := earlyStop.start(ctx)
+	defer stopper.done()
+	ctx = stopper.ctx
+	workerPool := utils.NewWorkerPoolWithOptions(ctx, traceRoutines, poolOptions)
+	probeCtx := workerPool.ProbeContext(ctx) // 每次探测前按 ProbeRateLimit 等待
+	bar := utils.NewBar(len(*s), "", "")
+	for i := 0; i < len(*s); i++ {
+		sr := &((*s)[i])
+		err := workerPool.Submit(func() {
+			sr.TraceTest(
+				probeCtx,
+				httpColo,
+				&httpColoSet,
+				traceTimes,
+				traceTimeout,
+				traceURL,
+				traceTCPPort,
+				bar,
+			)
+			stopper.check(sr)
+			if sr.Tested() {
+				workerPool.Report(sr.timeouts, sr.Sended)
+			}
+		})
+		if err != nil { // 已中断，剩余 IP 不再测试
+			break
+		}
+	}
+	workerPool.Wait()
+	bar.Done()
+	workerPool.Stop()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"CloudflareSpeedTest/config"
+	"CloudflareSpeedTest/speedTest"
+	"context"
+	"fmt"
+)
+
+// 按测速模式测试延迟
+func latencyTest(ctx context.Context, mode string, s *speedTest.SpeedResultSlice, earlyStop speedTest.EarlyStop) {
+	switch mode {
+	case "tcp":
+		s.TcpTest(
+			ctx,
+			config.Config.TcpRoutines,
+			workerPoolOptions(),
+			earlyStop,
+			config.Config.TcpPort,
+			config.Config.TcpConnectTimes,
+			config.Config.TcpConnectTimeout,
+		)
+	case "tls":
+		s.TlsTest(
+			ctx,
+			config.Config.TcpRoutines,
+			workerPoolOptions(),
+			earlyStop,
+			config.Config.TcpPort,
+			config.Config.TLSServerName,
+			config.Config.TcpConnectTimes,
+			config.Config.TcpConnectTimeout,
+		)
+	case "http":
+		s.HttpTest(
+			ctx,
+			config.Config.HttpColo,
+			config.Config.HttpColoSet,
+			config.Config.HttpConnectTimes,
+			config.Config.HttpConnectTimeout,
+			config.Config.HttpRoutines,
+			workerPoolOptions(),
+			earlyStop,
+			config.Config.HttpStatusCode,
+			config.Config.HttpURL,
+			config.Config.HttpTCPPort,
+		)
+	case "trace":
+		s.TraceTest(
+			ctx,
+			config.Config.HttpColo,
+			config.Config.HttpColoSet,
+			config.Config.HttpConnectTimes,
+			config.Config.HttpConnectTimeout,
+			config.Config.HttpRoutines,
+			workerPoolOptions(),
+			earlyStop,
+			config.Config.TraceURL,
+			config.Config.HttpTCPPort,
+		)
+	default:
+		fmt.Fprintf(config.InfoOutput, "[信息] 未知的测速模式: %s\n", mode)
+	}
+}
+
+// 依次执行各个阶段，每个阶段只测试上一阶段留下的前 N 个 IP，
+// 返回后 s 的前面是通过全部阶段的 IP，返回值为其数量
+func runStages(ctx context.Context, s *speedTest.SpeedResultSlice) int {
+	active := len(*s)
+	for i, stage := range config.Config.Stages {
+		if ctx.Err() != nil || active == 0 {
+			break
+		}
+		fmt.Fprintf(config.InfoOutput, "Stage %d/%d %s: %d IPs\n", i+1, len(config.Config.Stages), stage.Mode, active)
+		candidates := (*s)[:active]
+		threshold := speedTest.Threshold{
+			MaxDelay:    stage.MaxDelay,
+			MaxLossRate: stage.MaxLossRate,
+			MinSpeed:    stage.MinSpeed * 1024 * 1024,
+		}
+		earlyStop := speedTest.EarlyStop{Count: stage.StopCount, Threshold: threshold}
+		stageCtx, cancel := speedTest.WithBudget(ctx, stage.Timeout)
+		if stage.Mode == "download" {
+			candidates.DownloadTest(
+				stageCtx,
+				len(candidates),
+				config.Config.DownloadIPTestTimes,
+				config.Config.DownloadTimeout,
+				config.Config.DownloadURL,
+				config.Config.DownloadTCPPort,
+				earlyStop,
+			)
+			candidates.SortByDownloadSpeedDelayLossRate()
+		} else {
+			latencyTest(stageCtx, stage.Mode, &candidates, earlyStop)
+			candidates.SortByDelayLossRate()
+		}
+		if stageCtx.Err() != nil && ctx.Err() == nil {
+			fmt.Fprintf(config.InfoOutput, "[信息] 阶段 %s 已用完时间预算\n", stage.Mode)
+		}
+		cancel()
+		active = candidates.Funnel(threshold, stage.Limit)
+	}
+	// 未进入最后阶段的 IP 按延迟排在后面
+	rest := (*s)[active:]
+	rest.SortByDelayLossRate()
+	return active
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []string{
+		"127.0.0.1 localhost",
+		"",
+		HostsBlockBegin,
+		"1.1.1.1 a.com",
+		"1.1.1.1 b.com",
+		"1.1.1.1 c.com",
+		HostsBlockEnd,
+	}
+	b := append([]string(nil), a...)
+	b[4] = "1.0.0.1 b.com"
+	want := strings.Join([]string{
+		"--- hosts",
+		"+++ hosts (dry-run)",
+		"@@ -2,6 +2,6 @@",
+		" ",
+		" " + HostsBlockBegin,
+		" 1.1.1.1 a.com",
+		"-1.1.1.1 b.com",
+		"+1.0.0.1 b.com",
+		" 1.1.1.1 c.com",
+		" " + HostsBlockEnd,
+	}, "\n") + "\n"
+	if got := UnifiedDiff("hosts", "hosts (dry-run)", a, b, 3); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := UnifiedDiff("hosts", "hosts", a, a, 3); got != "" {
+		t.Errorf("没有差异时应返回空字符串: %q", got)
+	}
+}
+
+func TestUnifiedDiffDeletionsFirst(t *testing.T) {
+	got := UnifiedDiff("a", "b", []string{"x", "1", "2", "y"}, []string{"x", "3", "4", "y"}, 1)
+	want := "--- a\n+++ b\n@@ -1,4 +1,4 @@\n x\n-1\n-2\n+3\n+4\n y\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// 生成的差异应能被 patch 接受并得到新的内容
+func TestUnifiedDiffPatch(t *testing.T) {
+	if _, err := exec.LookPath("patch"); err != nil {
+		t.Skip("没有 patch 命令")
+	}
+	var a []string
+	for i := 0; i < 30; i++ {
+		a = append(a, "line "+string(rune('a'+i%26)))
+	}
+	tests := []struct {
+		name string
+		edit func([]string) []string
+	}{
+		{"替换一行", func(l []string) []string { l[10] = "changed"; return l }},
+		{"开头插入", func(l []string) []string { return append([]string{"new"}, l...) }},
+		{"末尾删除", func(l []string) []string { return l[:len(l)-2] }},
+		{"相隔较远的两处修改", func(l []string) []string { l[2] = "x"; l[25] = "y"; return l }},
+		{"相隔较近的替换和删除", func(l []string) []string {
+			l[5] = "x"
+			return append(l[:9], l[10:]...)
+		}},
+		{"清空", func(l []string) []string { return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.edit(append([]string(nil), a...))
+			dir := t.TempDir()
+			path := filepath.Join(dir, "file")
+			if err := os.WriteFile(path, []byte(strings.Join(a, "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			diff := UnifiedDiff("file", "file", a, b, 3)
+			cmd := exec.Command("patch", "--dry-run", "-p0", "file")
+			cmd.Dir = dir
+			cmd.Stdin = strings.NewReader(diff)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("patch --dry-run 失败: %v\n%s\n%s", err, out, diff)
+			}
+			cmd = exec.Command("patch", "-s", "-p0", "file")
+			cmd.Dir = dir
+			cmd.Stdin = strings.NewReader(diff)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("patch 失败: %v\n%s", err, out)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.Join(b, "\n") + "\n"
+			if len(b) == 0 {
+				want = ""
+			}
+			if string(data) != want {
+				t.Fatalf("patch 后的内容\n%s\n应为\n%s", data, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"CloudflareSpeedTest/config"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// DNSConfigFile 把 hosts 记录渲染为 dnsmasq、unbound 或 CoreDNS hosts 插件使用的配置文件，
+// 内容变化时才写入，写入后执行 ReloadCommand；DryRun 时只输出 diff
+type DNSConfigFile struct {
+	Format        string // dnsmasq, unbound or coredns
+	Path          string
+	ReloadCommand string
+	TTL           int // unbound local-data TTL, 0 means unbound default
+	DryRun        bool
+}
+
+func NewDNSConfigFile(format, path, reloadCommand string, ttl int) (*DNSConfigFile, error) {
+	switch format {
+	case "dnsmasq", "unbound", "coredns":
+	default:
+		return nil, fmt.Errorf("不支持的 DNS 配置格式 [%s]，可选 dnsmasq / unbound / coredns", format)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("DNS 配置格式 [%s] 未指定输出路径", format)
+	}
+	return &DNSConfigFile{Format: format, Path: path, ReloadCommand: reloadCommand, TTL: ttl}, nil
+}
+
+// Render 渲染配置文件内容
+func (d *DNSConfigFile) Render(entries []HostsEntry) string {
+	var b strings.Builder
+	b.WriteString("# Generated by CloudflareSpeedTest, do not edit\n")
+	if d.Format == "unbound" {
+		b.WriteString("server:\n")
+	}
+	for _, entry := range entries {
+		switch d.Format {
+		case "dnsmasq":
+			fmt.Fprintf(&b, "address=/%s/%s\n", entry.Host, entry.IP)
+		case "unbound":
+			recordType := "A"
+			if ip := net.ParseIP(entry.IP); ip != nil && ip.To4() == nil {
+				recordType = "AAAA"
+			}
+			ttl := ""
+			if d.TTL > 0 {
+				ttl = fmt.Sprintf(" %d", d.TTL)
+			}
+			fmt.Fprintf(&b, "    local-data: \"%s.%s IN %s %s\"\n", entry.Host, ttl, recordType, entry.IP)
+		case "coredns":
+			fmt.Fprintf(&b, "%s %s\n", entry.IP, entry.Host)
+		}
+	}
+	return b.String()
+}
+
+// Update 写入配置文件，内容不变时不写入也不执行 ReloadCommand
+func (d *DNSConfigFile) Update(entries []HostsEntry) error {
+	changed, err := WriteFileIfChanged(d.Path, d.Render(entries), d.DryRun)
+	if err != nil || !changed || d.DryRun || d.ReloadCommand == "" {
+		return err
+	}
+	err = RunShellCommand(d.ReloadCommand)
+	if err != nil {
+		return fmt.Errorf("执行重载命令 [%s] 失败：%v", d.ReloadCommand, err)
+	}
+	return nil
+}
+
+// RunShellCommand 通过系统 shell 执行命令，输出直接打印到终端
+func RunShellCommand(command string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout = config.InfoOutput // 不混入 -format 输出的结果
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
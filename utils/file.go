@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"CloudflareSpeedTest/config"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// WriteFileAtomic 先写入同目录下的临时文件再重命名，写入过程中崩溃不会留下不完整的文件。
+// 只有目标文件不能被替换时（例如容器中 bind mount 的 hosts 文件，重命名返回 EBUSY）
+// 才输出警告并直接覆盖写入，其他错误都返回给调用方
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm() // 保留原文件权限
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败：%v", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // 重命名成功后文件已不存在，忽略错误
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		if !errors.Is(err, syscall.EBUSY) {
+			return err
+		}
+		Yellow.Printf("[信息] %s 不能被替换（%v），改为直接覆盖写入\n", path, err)
+		return os.WriteFile(path, data, perm)
+	}
+	return nil
+}
+
+// WriteFileIfChanged 内容与现有文件不同时才写入，返回是否有变化；dryRun 时只输出 diff
+func WriteFileIfChanged(path string, content string, dryRun bool) (bool, error) {
+	old, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if string(old) == content {
+		return false, nil
+	}
+	if dryRun {
+		fmt.Fprint(config.InfoOutput, UnifiedDiff(path, path+" (dry-run)", splitLines(string(old)), splitLines(content), 3))
+		return true, nil
+	}
+	return true, WriteFileAtomic(path, []byte(content), 0644)
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
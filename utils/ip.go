@@ -12,23 +12,17 @@ import (
 	"github.com/RoaringBitmap/roaring"
 )
 
+// LoadResultIPV4 读取结果文件中的 IPv4 地址，忽略 IPv6 和无效的行
 func LoadResultIPV4(resultIPV4File string) *[]uint32 {
 	var bestIPV4 = make([]uint32, 0)
-	data, err := os.ReadFile(resultIPV4File)
+	_, records, err := ReadResultsCSV(resultIPV4File)
 	if err != nil {
 		return &bestIPV4
 	}
-	lines := strings.Split(string(data), "\n")
-	// skip header
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		ipv4Str := line[0:strings.Index(line, ",")]
-		v4, err := IPStringToUint32(ipv4Str)
+	for _, record := range records {
+		v4, err := IPStringToUint32(record["ip"])
 		if err != nil {
-			return &bestIPV4
+			continue
 		}
 		bestIPV4 = append(bestIPV4, v4)
 	}
@@ -96,30 +90,50 @@ func saveIPV4RB(rb *roaring.Bitmap, dbFile string) error {
 	return err
 }
 
-func SaveBestAllowDenyIPV4(
-	allowIPV4 *[]uint32,
-	denyIPV4 *[]uint32,
-	allowIPV4RBFile string,
-	denyIPV4RBFile string) error {
-	allowIPV4RB := loadIPV4RB(allowIPV4RBFile)
-	denyIPV4RB := loadIPV4RB(denyIPV4RBFile)
+// IPV4Store 黑白名单，守护模式下常驻内存，每轮结束后写回文件
+type IPV4Store struct {
+	Allow *roaring.Bitmap
+	Deny  *roaring.Bitmap
+}
+
+func LoadIPV4Store(allowIPV4RBFile string, denyIPV4RBFile string) *IPV4Store {
+	return &IPV4Store{
+		Allow: loadIPV4RB(allowIPV4RBFile),
+		Deny:  loadIPV4RB(denyIPV4RBFile),
+	}
+}
+
+func (st *IPV4Store) Add(allowIPV4 *[]uint32, denyIPV4 *[]uint32) {
 	for _, ip := range *allowIPV4 {
-		allowIPV4RB.Add(ip)
+		st.Allow.Add(ip)
 	}
 	for _, ip := range *denyIPV4 {
-		denyIPV4RB.Add(ip)
+		st.Deny.Add(ip)
 	}
-	err := saveIPV4RB(allowIPV4RB, allowIPV4RBFile)
+}
+
+func (st *IPV4Store) Save(allowIPV4RBFile string, denyIPV4RBFile string) error {
+	err := saveIPV4RB(st.Allow, allowIPV4RBFile)
 	if err != nil {
 		return err
 	}
-	err = saveIPV4RB(denyIPV4RB, denyIPV4RBFile)
+	err = saveIPV4RB(st.Deny, denyIPV4RBFile)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func SaveBestAllowDenyIPV4(
+	allowIPV4 *[]uint32,
+	denyIPV4 *[]uint32,
+	allowIPV4RBFile string,
+	denyIPV4RBFile string) error {
+	st := LoadIPV4Store(allowIPV4RBFile, denyIPV4RBFile)
+	st.Add(allowIPV4, denyIPV4)
+	return st.Save(allowIPV4RBFile, denyIPV4RBFile)
+}
+
 func IsIPv4(ip string) bool {
 	return strings.Contains(ip, ".")
 }
@@ -223,14 +237,21 @@ func GetIPs(
 	lastOutputFile string,
 	allowIPV4RBFile string,
 	denyIPV4RBFile string) []*net.IPAddr {
+	resultIPV4 := LoadResultIPV4(lastOutputFile)
+	return GetIPsByStore(cidrFile, want, resultIPV4, LoadIPV4Store(allowIPV4RBFile, denyIPV4RBFile))
+}
+
+// GetIPsByStore 与 GetIPs 相同，但上次结果和黑白名单由调用方提供（守护模式常驻内存）
+func GetIPsByStore(
+	cidrFile string,
+	want int,
+	resultIPV4 *[]uint32,
+	store *IPV4Store) []*net.IPAddr {
 	cidrs, err := loadCIDRTextSlice(cidrFile)
 	if err != nil {
 		return nil
 	}
-	resultIPV4 := LoadResultIPV4(lastOutputFile)
-	allowIPV4RB := loadIPV4RB(allowIPV4RBFile)
-	denyIPV4RB := loadIPV4RB(denyIPV4RBFile)
-	ips, err := getIPsByCIDRs(cidrs, want, resultIPV4, allowIPV4RB, denyIPV4RB)
+	ips, err := getIPsByCIDRs(cidrs, want, resultIPV4, store.Allow, store.Deny)
 	if err != nil {
 		return nil
 	}
@@ -256,6 +277,63 @@ func getIPsNumByCIDRs(cidrs []string) (int, error) {
 	return totalIpsNum, nil
 }
 
+// CIDRStats 一个 IPv4 网段在黑白名单中的扫描情况
+type CIDRStats struct {
+	CIDR         string  `json:"cidr"`
+	Size         uint64  `json:"size"`
+	Scanned      uint64  `json:"scanned"` // 在白名单或黑名单中的地址数
+	Allow        uint64  `json:"allow"`
+	Deny         uint64  `json:"deny"`
+	Coverage     float64 `json:"coverage"`      // Scanned / Size
+	SuccessRatio float64 `json:"success_ratio"` // Allow / (Allow + Deny)
+}
+
+// 地址在 [first, last] 中的数量
+func rangeCardinality(rb *roaring.Bitmap, first, last uint32) uint64 {
+	n := rb.Rank(last)
+	if first > 0 {
+		n -= rb.Rank(first - 1)
+	}
+	return n
+}
+
+// GetCIDRStats 按 CIDR 文件中的网段统计黑白名单，跳过 IPv6 网段
+func GetCIDRStats(cidrFile string, store *IPV4Store) ([]CIDRStats, error) {
+	cidrs, err := loadCIDRTextSlice(cidrFile)
+	if err != nil {
+		return nil, err
+	}
+	both := roaring.And(store.Allow, store.Deny) // 先后进入过黑白名单的地址只算一次
+	var stats []CIDRStats
+	for _, cidr := range cidrs {
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ones, bits := ipnet.Mask.Size()
+		if bits != 32 {
+			continue
+		}
+		ip = ip.Mask(ipnet.Mask)
+		first := NetIPIPV4toUint32(&ip)
+		size := uint64(1) << (bits - ones)
+		last := first + uint32(size-1)
+		st := CIDRStats{
+			CIDR:  ipnet.String(),
+			Size:  size,
+			Allow: rangeCardinality(store.Allow, first, last),
+			Deny:  rangeCardinality(store.Deny, first, last),
+		}
+		st.Scanned = st.Allow + st.Deny - rangeCardinality(both, first, last)
+		st.Coverage = float64(st.Scanned) / float64(st.Size)
+		if st.Allow+st.Deny > 0 {
+			st.SuccessRatio = float64(st.Allow) / float64(st.Allow+st.Deny)
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
 func ShowIPStatus(
 	cidrFile string,
 	allowIPV4RBFile string,
@@ -265,7 +343,7 @@ func ShowIPStatus(
 	allowIPV4RB := loadIPV4RB(allowIPV4RBFile)
 	denyIPV4RB := loadIPV4RB(denyIPV4RBFile)
 
-	fmt.Printf("total ipv4 num: %d\n", totalIpsNum)
-	fmt.Printf("allow ipv4 num: %d\n", allowIPV4RB.GetCardinality())
-	fmt.Printf("deny ipv4 num: %d\n", denyIPV4RB.GetCardinality())
+	fmt.Fprintf(config.InfoOutput, "total ipv4 num: %d\n", totalIpsNum)
+	fmt.Fprintf(config.InfoOutput, "allow ipv4 num: %d\n", allowIPV4RB.GetCardinality())
+	fmt.Fprintf(config.InfoOutput, "deny ipv4 num: %d\n", denyIPV4RB.GetCardinality())
 }
@@ -16,10 +16,17 @@ func NewBar(count int, MyStrStart, MyStrEnd string) *Bar {
 	return &Bar{pb: bar}
 }
 
+// Grow nil 的 Bar 不显示进度，用于单个 IP 的测试
 func (b *Bar) Grow(num int, MyStrVal string) {
+	if b == nil {
+		return
+	}
 	b.pb.Set("MyStr", MyStrVal).Add(num)
 }
 
 func (b *Bar) Done() {
+	if b == nil {
+		return
+	}
 	b.pb.Finish()
 }
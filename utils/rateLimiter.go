@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter 按固定间隔放行，限制每秒开始的探测数量
+type RateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time // 下一个可用的放行时间
+}
+
+// NewRateLimiter perSecond <= 0 时返回 nil，表示不限速
+func NewRateLimiter(perSecond int) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// Wait 阻塞到轮到自己为止，ctx 被取消时返回错误
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	if r == nil {
+		return ctx.Err()
+	}
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval) // 先占位，多个 worker 依次排队
+	r.mu.Unlock()
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimiterKey struct{}
+
+// WithRateLimiter 把限速器放入 ctx，探测时用 WaitProbe 等待放行
+func WithRateLimiter(ctx context.Context, r *RateLimiter) context.Context {
+	if r == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, rateLimiterKey{}, r)
+}
+
+// WaitProbe 每次连接或请求前调用，ctx 中没有限速器时只检查是否已取消
+func WaitProbe(ctx context.Context) error {
+	r, _ := ctx.Value(rateLimiterKey{}).(*RateLimiter)
+	return r.Wait(ctx)
+}
+
+// IsTimeout 是否为超时错误，连接被拒绝等错误不算
+func IsTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
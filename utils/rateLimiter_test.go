@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWaitProbe(t *testing.T) {
+	t.Run("没有限速器时直接放行", func(t *testing.T) {
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			if err := WaitProbe(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if d := time.Since(start); d > 50*time.Millisecond {
+			t.Fatalf("不限速时耗时 %v", d)
+		}
+	})
+
+	t.Run("按间隔放行", func(t *testing.T) {
+		ctx := WithRateLimiter(context.Background(), NewRateLimiter(100))
+		start := time.Now()
+		for i := 0; i < 11; i++ {
+			if err := WaitProbe(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 第一次立即放行，之后每次间隔 10ms
+		if d := time.Since(start); d < 90*time.Millisecond {
+			t.Fatalf("11 次探测耗时 %v，应至少 100ms", d)
+		}
+	})
+
+	t.Run("取消后立即返回错误", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(WithRateLimiter(context.Background(), NewRateLimiter(1)))
+		if err := WaitProbe(ctx); err != nil { // 占用第一个名额，下一次需等待 1s
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		start := time.Now()
+		if err := WaitProbe(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v，应为 context.Canceled", err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatalf("取消后仍等待了 %v", d)
+		}
+	})
+
+	t.Run("不限速但已取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := WaitProbe(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v，应为 context.Canceled", err)
+		}
+	})
+}
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{os.ErrDeadlineExceeded, true},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, false},
+		{errors.New("EOF"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsTimeout(tt.err); got != tt.want {
+			t.Errorf("IsTimeout(%v) = %v，应为 %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	if NewRateLimiter(0) != nil || NewRateLimiter(-1) != nil {
+		t.Fatal("perSecond <= 0 时应不限速")
+	}
+	if r := NewRateLimiter(4); r.interval != 250*time.Millisecond {
+		t.Fatalf("interval = %v", r.interval)
+	}
+}
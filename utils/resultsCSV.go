@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 结果文件格式：第一行为版本标记，第二行为英文列名，之后每行一个结果。
+// v1 没有版本标记，表头为中文，下载速度单位为 MB/s；v2 起下载速度单位为字节/秒
+const (
+	ResultsSchemaVersion = 2
+	resultsVersionPrefix = "# CloudflareSpeedTest results v"
+)
+
+// ResultColumns 当前版本的列名
+var ResultColumns = []string{"ip", "sent", "received", "loss_rate", "delay_ms", "download_speed", "colo", "mode", "tested_at"}
+
+// v1 的中文表头对应的列名
+var resultsV1Columns = map[string]string{
+	"IP 地址":      "ip",
+	"已发送":        "sent",
+	"已接收":        "received",
+	"丢包率":        "loss_rate",
+	"平均延迟":       "delay_ms",
+	"下载速度(MB/s)": "download_speed",
+	"地区码":        "colo",
+}
+
+// ResultRecord 结果文件中的一行，以列名取值，缺少的列为空字符串
+type ResultRecord map[string]string
+
+// WriteResultsCSV 写出版本标记、表头和各行，rows 的列顺序与 ResultColumns 相同
+func WriteResultsCSV(w io.Writer, rows [][]string) error {
+	_, err := fmt.Fprintf(w, "%s%d\n", resultsVersionPrefix, ResultsSchemaVersion)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(ResultColumns)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
+
+// ReadResultsCSV 读取任意版本的结果文件，返回文件的版本和换算为当前版本含义的各行：
+// 按表头列名取值，不认识的列忽略（较新的版本），缺少的列为空（较旧的版本）
+func ReadResultsCSV(path string) (int, []ResultRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 1
+	reader := bufio.NewReader(bytes.NewReader(data))
+	if bytes.HasPrefix(data, []byte(resultsVersionPrefix)) {
+		line, _ := reader.ReadString('\n')
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, resultsVersionPrefix)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("结果文件[%s]的版本标记无效：%s", path, strings.TrimSpace(line))
+		}
+	}
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	lines, err := r.ReadAll()
+	if err != nil {
+		return version, nil, err
+	}
+	if len(lines) == 0 {
+		return version, nil, nil
+	}
+	header := lines[0]
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if column, ok := resultsV1Columns[name]; ok {
+			name = column
+		}
+		header[i] = name
+	}
+	records := make([]ResultRecord, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		record := make(ResultRecord, len(header))
+		for i, value := range line {
+			if i < len(header) {
+				record[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		if version == 1 {
+			upgradeResultV1(record)
+		}
+		records = append(records, record)
+	}
+	return version, records, nil
+}
+
+// v1 的下载速度为 MB/s，地区码未知时为 N/A
+func upgradeResultV1(record ResultRecord) {
+	if speed, err := strconv.ParseFloat(record["download_speed"], 64); err == nil {
+		record["download_speed"] = strconv.FormatFloat(speed*1024*1024, 'f', 0, 64)
+	}
+	if record["colo"] == "N/A" {
+		record["colo"] = ""
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadResultsCSV(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantVersion int
+		want        []ResultRecord
+	}{
+		{
+			name: "v1 中文表头，下载速度从 MB/s 换算为字节/秒",
+			content: "\ufeffIP 地址,已发送,已接收,丢包率,平均延迟,下载速度(MB/s),地区码\n" +
+				"1.1.1.1,4,4,0.00,123.45,12.34,HKG\n" +
+				"1.0.0.1,4,3,0.25,200.00,0.00,N/A\n",
+			wantVersion: 1,
+			want: []ResultRecord{
+				{"ip": "1.1.1.1", "sent": "4", "received": "4", "loss_rate": "0.00", "delay_ms": "123.45", "download_speed": "12939428", "colo": "HKG"},
+				{"ip": "1.0.0.1", "sent": "4", "received": "3", "loss_rate": "0.25", "delay_ms": "200.00", "download_speed": "0", "colo": ""},
+			},
+		},
+		{
+			name: "v2 带版本标记，数值不换算",
+			content: "# CloudflareSpeedTest results v2\n" +
+				"ip,sent,received,loss_rate,delay_ms,download_speed,colo,mode,tested_at\n" +
+				"1.1.1.1,4,4,0.00,123.45,12939428,HKG,tcping,2026-01-02T03:04:05Z\n",
+			wantVersion: 2,
+			want: []ResultRecord{
+				{"ip": "1.1.1.1", "sent": "4", "received": "4", "loss_rate": "0.00", "delay_ms": "123.45", "download_speed": "12939428", "colo": "HKG", "mode": "tcping", "tested_at": "2026-01-02T03:04:05Z"},
+			},
+		},
+		{
+			name: "更新的版本按列名读取，多出的列不影响已知列",
+			content: "# CloudflareSpeedTest results v9\n" +
+				"jitter_ms,ip,download_speed,delay_ms\n" +
+				"1.5,1.1.1.1,1000,50.00\n",
+			wantVersion: 9,
+			want: []ResultRecord{
+				{"jitter_ms": "1.5", "ip": "1.1.1.1", "download_speed": "1000", "delay_ms": "50.00"},
+			},
+		},
+		{
+			name:        "只有版本标记和表头",
+			content:     "# CloudflareSpeedTest results v2\nip,sent\n",
+			wantVersion: 2,
+			want:        []ResultRecord{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "result.csv")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			version, records, err := ReadResultsCSV(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("version = %d，应为 %d", version, tt.wantVersion)
+			}
+			if !reflect.DeepEqual(records, tt.want) {
+				t.Errorf("records = %v\n应为 %v", records, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadResultsCSVInvalidVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.csv")
+	if err := os.WriteFile(path, []byte("# CloudflareSpeedTest results vX\nip\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadResultsCSV(path); err == nil {
+		t.Fatal("版本标记无效时应返回错误")
+	}
+}
+
+func TestResultsCSVRoundTrip(t *testing.T) {
+	rows := [][]string{
+		{"1.1.1.1", "4", "4", "0.00", "123.45", "12939428", "HKG", "tcping", "2026-01-02T03:04:05Z"},
+		{"2606:4700::1", "4", "0", "1.00", "0.00", "0", "", "httping", "2026-01-02T03:04:06Z"},
+	}
+	var buf bytes.Buffer
+	if err := WriteResultsCSV(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "result.csv")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	version, records, err := ReadResultsCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != ResultsSchemaVersion {
+		t.Errorf("version = %d，应为 %d", version, ResultsSchemaVersion)
+	}
+	if len(records) != len(rows) {
+		t.Fatalf("读到 %d 行，应为 %d 行", len(records), len(rows))
+	}
+	for i, row := range rows {
+		for j, column := range ResultColumns {
+			if got := records[i][column]; got != row[j] {
+				t.Errorf("第 %d 行 %s = %q，应为 %q", i+1, column, got, row[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 守护模式下计算下一次测速的时间
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// IntervalSchedule 固定间隔
+type IntervalSchedule time.Duration
+
+func (i IntervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// CronSchedule 标准 5 段 cron 表达式：分 时 日 月 周
+// 支持 *、列表（1,2）、范围（1-5）和步长（*/10、0-30/5），周日为 0 或 7
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64 // 按位记录允许的取值
+	domAny, dowAny                bool
+}
+
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron 表达式需要 5 段: %q", expr)
+	}
+	var c CronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if c.dow&(1<<7) != 0 { // 7 也表示周日
+		c.dow |= 1
+	}
+	// 与 Vixie cron 一致，以 * 开头（如 */1）的日、周字段视为不限制
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	if _, ok := c.next(time.Now()); !ok {
+		return nil, fmt.Errorf("cron 表达式在 %d 年内没有可执行的时间: %q", cronSearchYears, expr)
+	}
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("cron 步长无效: %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rangePart != "*" {
+			loStr, hiStr, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("cron 取值无效: %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("cron 取值无效: %q", part)
+				}
+			} else if hasStep {
+				hi = max // 5/10 表示从 5 开始每 10 个
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("cron 取值超出范围 %d-%d: %q", min, max, part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domOk := c.dom&(1<<uint(t.Day())) != 0
+	dowOk := c.dow&(1<<uint(t.Weekday())) != 0
+	// 与 cron 一致：日和周都有限制时，满足其一即可
+	if !c.domAny && !c.dowAny {
+		return domOk || dowOk
+	}
+	return domOk && dowOk
+}
+
+// 查找下一次执行时间的范围，覆盖一个闰年周期
+const cronSearchYears = 5
+
+// Next 返回 t 之后（不含 t 所在的分钟）第一个匹配的时间；ParseCron 已排除找不到的表达式
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	next, _ := c.next(t)
+	return next
+}
+
+func (c *CronSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, true
+	}
+	return limit, false
+}
+
+// NewSchedule cron 表达式优先，否则使用固定间隔
+func NewSchedule(cronExpr string, interval time.Duration) (Schedule, error) {
+	if cronExpr != "" {
+		c, err := ParseCron(cronExpr)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("守护模式需要配置 DaemonInterval 或 DaemonCron")
+	}
+	return IntervalSchedule(interval), nil
+}
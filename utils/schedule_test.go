@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsImpossible(t *testing.T) {
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) 应返回错误", expr)
+		}
+	}
+	if _, err := ParseCron("0 0 29 2 *"); err != nil {
+		t.Errorf("2 月 29 日在闰年可执行: %v", err)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC) // 周一
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 15 * *", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		// 日和周都有限制时满足其一即可
+		{"0 0 15 * 3", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		// */1 的周字段视为不限制，只按日匹配
+		{"0 0 15 * */1", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */1 * 3", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 0", time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := c.Next(base); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
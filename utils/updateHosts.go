@@ -2,10 +2,19 @@ package utils
 
 import (
 	"CloudflareSpeedTest/config"
-	"bufio"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"time"
+)
+
+const (
+	HostsBlockBegin = "# BEGIN CloudflareSpeedTest"
+	HostsBlockEnd   = "# END CloudflareSpeedTest"
 )
 
 type StringSet map[string]struct{}
@@ -13,6 +22,69 @@ type StringSet map[string]struct{}
 func (s StringSet) Add(v string)           { s[v] = struct{}{} }
 func (s StringSet) Contains(v string) bool { _, ok := s[v]; return ok }
 
+// HostsEntry hosts 文件中的一条记录
+type HostsEntry struct {
+	IP   string
+	Host string
+}
+
+// NewHostsEntries 每个域名对应 ips 中的每个 IP（一般是一个 IPv4 和/或一个 IPv6）
+func NewHostsEntries(ips []string, hosts []string) []HostsEntry {
+	var entries []HostsEntry
+	for _, host := range hosts {
+		for _, ip := range ips {
+			entries = append(entries, HostsEntry{IP: ip, Host: strings.ToLower(host)})
+		}
+	}
+	return entries
+}
+
+// 同一个域名的 IPv4 和 IPv6 记录分别维护
+func (e HostsEntry) key() string {
+	if IsIPv4(e.IP) {
+		return e.Host + " 4"
+	}
+	return e.Host + " 6"
+}
+
+// 区块内的一行，raw 不为空时是注释或空行，原样保留
+type hostsBlockLine struct {
+	entry   HostsEntry
+	comment string
+	raw     string
+}
+
+func (l hostsBlockLine) String() string {
+	if l.entry.Host == "" {
+		return l.raw
+	}
+	line := l.entry.IP + " " + l.entry.Host
+	if l.comment != "" {
+		line += " " + l.comment
+	}
+	return line
+}
+
+// 解析区块内的一行，一行多个域名时拆成多行，保留行尾注释
+func parseHostsBlockLine(line string) []hostsBlockLine {
+	content, comment := line, ""
+	if i := strings.Index(line, "#"); i >= 0 {
+		content, comment = line[:i], strings.TrimSpace(line[i:])
+	}
+	fields := strings.Fields(content)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return []hostsBlockLine{{raw: line}}
+	}
+	var lines []hostsBlockLine
+	for _, host := range fields[1:] {
+		lines = append(lines, hostsBlockLine{
+			entry:   HostsEntry{IP: fields[0], Host: strings.ToLower(host)},
+			comment: comment,
+		})
+	}
+	return lines
+}
+
 func getHostsFilePath() string {
 	var hostsFilePath string
 	if runtime.GOOS == "windows" {
@@ -22,70 +94,316 @@ func getHostsFilePath() string {
 	}
 	return hostsFilePath
 }
-func UpdateHosts(ip string, hosts []string) error {
-	if len(hosts) == 0 {
-		return nil
-	}
-	lowerHostsSet := make(StringSet)
-	for _, host := range hosts {
-		lowerHostsSet.Add(strings.ToLower(host))
+
+// HostsFile hosts 文件，修改前备份到 BackupDir，最多保留 BackupNum 个备份，
+// DryRun 时只输出将要修改的 diff，不写入
+type HostsFile struct {
+	Path      string
+	BackupDir string
+	BackupNum int
+	DryRun    bool
+}
+
+// NewHostsFile path 为空时使用系统的 hosts 文件
+func NewHostsFile(path string, backupDir string, backupNum int) *HostsFile {
+	if path == "" {
+		path = getHostsFilePath()
 	}
-	hostsFilePath := getHostsFilePath()
-	lines, err := readHostsFile(hostsFilePath)
+	return &HostsFile{Path: path, BackupDir: backupDir, BackupNum: backupNum}
+}
+
+// 输出当前内容到 lines 的 unified diff
+func (h *HostsFile) printDiff(lines []string) error {
+	oldLines, _, err := readHostsFile(h.Path)
 	if err != nil {
 		return err
 	}
+	diff := UnifiedDiff(h.Path, h.Path+" (dry-run)", oldLines, lines, 3)
+	if diff == "" {
+		fmt.Fprintf(config.InfoOutput, "[dry-run] %s 没有变化\n", h.Path)
+		return nil
+	}
+	fmt.Fprint(config.InfoOutput, diff)
+	return nil
+}
+
+// 找到区块的起止行，不存在时返回 -1, -1
+func findHostsBlock(lines []string) (int, int) {
+	begin := -1
 	for i, line := range lines {
-		// 跳过空行和注释
 		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
+		if begin < 0 && line == HostsBlockBegin {
+			begin = i
+		} else if begin >= 0 && line == HostsBlockEnd {
+			return begin, i
+		}
+	}
+	return -1, -1
+}
+
+// 计算更新后的 hosts 内容：只修改 BEGIN/END 区块内的行，区块不存在时追加到文件末尾。
+// 区块内已有的记录替换为新 IP 并保留行尾注释，缺少的记录追加到区块末尾；
+// entries 中的域名以 entries 为准，另一协议族的旧记录也会删除（如从 IPv6 切换到 IPv4），
+// removeStale 为 true 时删除 entries 中没有的域名
+func updateHostsLines(lines []string, entries []HostsEntry, removeStale bool) []string {
+	begin, end := findHostsBlock(lines)
+	var blockLines []hostsBlockLine
+	if begin >= 0 {
+		for _, line := range lines[begin+1 : end] {
+			blockLines = append(blockLines, parseHostsBlockLine(line)...)
+		}
+	}
+	newIPs := make(map[string]string)
+	newHosts := make(StringSet)
+	for _, entry := range entries {
+		if _, ok := newIPs[entry.key()]; !ok {
+			newIPs[entry.key()] = entry.IP
+		}
+		newHosts.Add(entry.Host)
+	}
+	written := make(StringSet)
+	var block []string
+	for _, line := range blockLines {
+		if line.entry.Host == "" {
+			block = append(block, line.raw)
 			continue
 		}
-		// 只取前两项：ip  host
-		fields := strings.Fields(line)
-		if len(fields) < 2 || !IsIPv4(fields[0]) {
+		key := line.entry.key()
+		ip, ok := newIPs[key]
+		if !ok {
+			if !removeStale && !newHosts.Contains(line.entry.Host) {
+				block = append(block, line.String())
+			}
+			continue
+		}
+		if written.Contains(key) { // 重复的记录只保留第一条
 			continue
 		}
-		_, host := fields[0], fields[1]
-		lowerHost := strings.ToLower(host)
-		if lowerHostsSet.Contains(lowerHost) {
-			lines[i] = ip + " " + lowerHost // 替换
+		written.Add(key)
+		line.entry.IP = ip
+		block = append(block, line.String())
+	}
+	for _, entry := range entries {
+		if written.Contains(entry.key()) {
+			continue
+		}
+		written.Add(entry.key())
+		block = append(block, hostsBlockLine{entry: entry}.String())
+	}
+
+	result := make([]string, 0, len(lines)+len(block)+3)
+	if begin >= 0 {
+		result = append(result, lines[:begin+1]...)
+		result = append(result, block...)
+		result = append(result, lines[end:]...)
+		return result
+	}
+	result = append(result, lines...)
+	if len(result) > 0 && strings.TrimSpace(result[len(result)-1]) != "" {
+		result = append(result, "")
+	}
+	result = append(result, HostsBlockBegin)
+	result = append(result, block...)
+	result = append(result, HostsBlockEnd)
+	return result
+}
+
+// 区块外已有同名域名时，系统可能优先使用区块外的记录
+func warnHostsOutsideBlock(lines []string, entries []HostsEntry) {
+	hostsSet := make(StringSet)
+	for _, entry := range entries {
+		hostsSet.Add(entry.Host)
+	}
+	begin, end := findHostsBlock(lines)
+	for i, line := range lines {
+		if begin >= 0 && i >= begin && i <= end {
+			continue
+		}
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		for k := 1; k < len(fields); k++ {
+			if hostsSet.Contains(strings.ToLower(fields[k])) {
+				Yellow.Printf("[信息] hosts 第 %d 行在 CloudflareSpeedTest 区块之外也指定了 %s，可能覆盖区块内的记录\n", i+1, fields[k])
+			}
 		}
 	}
-	err = writeHostsFile(hostsFilePath, lines)
-	return err
 }
 
-func readHostsFile(hostsFilePath string) ([]string, error) {
-	file, err := os.Open(hostsFilePath)
+// Update 在 hosts 文件的 BEGIN/END 区块中写入 entries，不修改区块外的行
+func (h *HostsFile) Update(entries []HostsEntry, removeStale bool) error {
+	if len(entries) == 0 && !removeStale {
+		return nil
+	}
+	lines, newline, err := readHostsFile(h.Path)
+	if err != nil {
+		return err
+	}
+	newLines := updateHostsLines(lines, entries, removeStale)
+	if strings.Join(newLines, "\n") == strings.Join(lines, "\n") {
+		return nil
+	}
+	warnHostsOutsideBlock(lines, entries)
+	return h.write(newLines, newline)
+}
+
+// RemoveBlock 删除整个 BEGIN/END 区块
+func (h *HostsFile) RemoveBlock() error {
+	lines, newline, err := readHostsFile(h.Path)
+	if err != nil {
+		return err
+	}
+	begin, end := findHostsBlock(lines)
+	if begin < 0 {
+		return nil
+	}
+	newLines := append(append([]string{}, lines[:begin]...), lines[end+1:]...)
+	return h.write(newLines, newline)
+}
+
+// GetIP 返回 CloudflareSpeedTest 区块中第一个指向 hosts 中任一域名的 IP
+func (h *HostsFile) GetIP(hosts []string) (string, error) {
+	entries, err := h.Entries(hosts)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("hosts 文件的 CloudflareSpeedTest 区块中没有找到 %v", hosts)
+	}
+	return entries[0].IP, nil
+}
+
+// Entries 返回 CloudflareSpeedTest 区块中 hosts 的全部记录，只包含以合法 IP 开头的行
+func (h *HostsFile) Entries(hosts []string) ([]HostsEntry, error) {
+	lowerHostsSet := make(StringSet)
+	for _, host := range hosts {
+		lowerHostsSet.Add(strings.ToLower(host))
+	}
+	lines, _, err := readHostsFile(h.Path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		lines = append(lines, strings.TrimSpace(line))
+	begin, end := findHostsBlock(lines)
+	if begin < 0 {
+		return nil, fmt.Errorf("hosts 文件中没有 CloudflareSpeedTest 区块")
+	}
+	var entries []HostsEntry
+	for _, line := range lines[begin+1 : end] {
+		for _, l := range parseHostsBlockLine(line) {
+			if lowerHostsSet.Contains(l.entry.Host) {
+				entries = append(entries, l.entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// 备份文件名前缀，如 hosts.20060102-150405.000.bak
+func (h *HostsFile) backupPrefix() string {
+	return filepath.Base(h.Path) + "."
+}
+
+// 按时间从旧到新排列的备份文件
+func (h *HostsFile) backups() ([]string, error) {
+	entries, err := os.ReadDir(h.BackupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
-	if err := scanner.Err(); err != nil {
+	if err != nil {
 		return nil, err
 	}
-	return lines, nil
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, h.backupPrefix()) && strings.HasSuffix(name, ".bak") {
+			backups = append(backups, filepath.Join(h.BackupDir, name))
+		}
+	}
+	sort.Strings(backups) // 文件名中的时间戳按字典序即时间顺序
+	return backups, nil
 }
 
-func writeHostsFile(hostsFilePath string, lines []string) error {
-	file, err := os.Create(hostsFilePath)
+// 备份当前的 hosts 文件，并删除超出 BackupNum 的旧备份
+func (h *HostsFile) backup() error {
+	data, err := os.ReadFile(h.Path)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(h.BackupDir, 0755)
+	if err != nil {
+		return err
+	}
+	backupFile := filepath.Join(h.BackupDir, h.backupPrefix()+time.Now().Format("20060102-150405.000")+".bak")
+	err = os.WriteFile(backupFile, data, 0644)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	writer := bufio.NewWriter(file)
-	for _, line := range lines {
-		_, err := writer.WriteString(line + "\n")
+	backups, err := h.backups()
+	if err != nil || h.BackupNum <= 0 {
+		return err
+	}
+	for i := 0; i < len(backups)-h.BackupNum; i++ {
+		os.Remove(backups[i])
+	}
+	return nil
+}
+
+// 先备份再原子写入
+func (h *HostsFile) write(lines []string, newline string) error {
+	if h.DryRun {
+		return h.printDiff(lines)
+	}
+	if h.BackupDir != "" {
+		err := h.backup()
 		if err != nil {
-			return err
+			return fmt.Errorf("备份 hosts 文件失败：%v", err)
 		}
 	}
-	return writer.Flush()
+	content := strings.Join(lines, newline) + newline
+	return WriteFileAtomic(h.Path, []byte(content), 0644)
+}
+
+// Rollback 用最近一次的备份恢复 hosts 文件，并删除该备份，再次执行会恢复到更早的版本；
+// DryRun 时只输出恢复后的 diff，不写入也不删除备份
+func (h *HostsFile) Rollback() (string, error) {
+	backups, err := h.backups()
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", fmt.Errorf("目录[%s]中没有 hosts 备份", h.BackupDir)
+	}
+	latest := backups[len(backups)-1]
+	data, err := os.ReadFile(latest)
+	if err != nil {
+		return "", err
+	}
+	if h.DryRun {
+		lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+		return latest, h.printDiff(lines)
+	}
+	err = WriteFileAtomic(h.Path, data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return latest, os.Remove(latest)
+}
+
+// 按行读取，保留每行原样，并返回文件使用的换行符
+func readHostsFile(hostsFilePath string) ([]string, string, error) {
+	data, err := os.ReadFile(hostsFilePath)
+	if err != nil {
+		return nil, "", err
+	}
+	content := string(data)
+	newline := "\n"
+	if strings.Contains(content, "\r\n") {
+		newline = "\r\n"
+	}
+	content = strings.TrimSuffix(content, newline)
+	if content == "" {
+		return nil, newline, nil
+	}
+	return strings.Split(content, newline), newline, nil
 }
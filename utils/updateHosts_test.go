@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateHostsLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []string
+		entries     []HostsEntry
+		removeStale bool
+		want        []string
+	}{
+		{
+			name:    "没有区块时追加到末尾",
+			lines:   []string{"127.0.0.1 localhost"},
+			entries: []HostsEntry{{"1.1.1.1", "a.com"}},
+			want:    []string{"127.0.0.1 localhost", "", HostsBlockBegin, "1.1.1.1 a.com", HostsBlockEnd},
+		},
+		{
+			name:    "替换 IP 并保留注释，区块外不变",
+			lines:   []string{"2.2.2.2 a.com", HostsBlockBegin, "# note", "2.2.2.2 a.com # keep", HostsBlockEnd, "# tail"},
+			entries: []HostsEntry{{"1.1.1.1", "a.com"}},
+			want:    []string{"2.2.2.2 a.com", HostsBlockBegin, "# note", "1.1.1.1 a.com # keep", HostsBlockEnd, "# tail"},
+		},
+		{
+			name:    "一行多个域名拆开，缺少的域名追加",
+			lines:   []string{HostsBlockBegin, "2.2.2.2 a.com b.com", HostsBlockEnd},
+			entries: []HostsEntry{{"1.1.1.1", "a.com"}, {"1.1.1.1", "c.com"}},
+			want:    []string{HostsBlockBegin, "1.1.1.1 a.com", "2.2.2.2 b.com", "1.1.1.1 c.com", HostsBlockEnd},
+		},
+		{
+			name:        "removeStale 删除不在 entries 中的域名",
+			lines:       []string{HostsBlockBegin, "2.2.2.2 a.com", "2.2.2.2 b.com", HostsBlockEnd},
+			entries:     []HostsEntry{{"1.1.1.1", "a.com"}},
+			removeStale: true,
+			want:        []string{HostsBlockBegin, "1.1.1.1 a.com", HostsBlockEnd},
+		},
+		{
+			name:    "IPv6 切换到 IPv4 时删除旧记录",
+			lines:   []string{HostsBlockBegin, "2606:4700::1 a.com", HostsBlockEnd},
+			entries: []HostsEntry{{"1.1.1.1", "a.com"}},
+			want:    []string{HostsBlockBegin, "1.1.1.1 a.com", HostsBlockEnd},
+		},
+		{
+			name:    "同一域名同时写入 IPv4 和 IPv6",
+			lines:   []string{HostsBlockBegin, "2.2.2.2 a.com", HostsBlockEnd},
+			entries: []HostsEntry{{"1.1.1.1", "a.com"}, {"2606:4700::1", "a.com"}},
+			want:    []string{HostsBlockBegin, "1.1.1.1 a.com", "2606:4700::1 a.com", HostsBlockEnd},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := updateHostsLines(tt.lines, tt.entries, tt.removeStale)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func newTestHostsFile(t *testing.T, content string, backupNum int) *HostsFile {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return NewHostsFile(path, filepath.Join(dir, "backup"), backupNum)
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHostsFileBackupRotationAndRollback(t *testing.T) {
+	h := newTestHostsFile(t, "127.0.0.1 localhost\r\n", 2)
+	var versions []string
+	for _, ip := range []string{"1.1.1.1", "1.0.0.1", "1.1.1.2"} {
+		versions = append(versions, readFile(t, h.Path))
+		if err := h.Update([]HostsEntry{{ip, "a.com"}}, false); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // 备份文件名精确到毫秒
+	}
+	if got := readFile(t, h.Path); !strings.Contains(got, "1.1.1.2 a.com\r\n") {
+		t.Fatalf("应保留 CRLF 换行并写入新 IP:\n%q", got)
+	}
+	backups, err := h.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("应只保留 2 个备份，实际 %d 个", len(backups))
+	}
+
+	// 依次恢复到更早的版本
+	for i := len(versions) - 1; i >= 1; i-- {
+		if _, err := h.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, h.Path); got != versions[i] {
+			t.Fatalf("第 %d 次恢复后内容为\n%q\n应为\n%q", len(versions)-i, got, versions[i])
+		}
+	}
+	if _, err := h.Rollback(); err == nil {
+		t.Fatal("备份用完后应返回错误")
+	}
+}
+
+func TestHostsFileDryRun(t *testing.T) {
+	const content = "127.0.0.1 localhost\n"
+	h := newTestHostsFile(t, content, 10)
+	h.DryRun = true
+	if err := h.Update([]HostsEntry{{"1.1.1.1", "a.com"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, h.Path); got != content {
+		t.Fatalf("dry-run 不应修改文件: %q", got)
+	}
+	if backups, _ := h.backups(); len(backups) != 0 {
+		t.Fatalf("dry-run 不应备份: %v", backups)
+	}
+}
+
+func TestHostsFileGetIP(t *testing.T) {
+	h := newTestHostsFile(t, strings.Join([]string{
+		"9.9.9.9 a.com",
+		HostsBlockBegin,
+		"not-an-ip a.com",
+		"1.1.1.1 B.com a.com",
+		HostsBlockEnd,
+	}, "\n")+"\n", 10)
+	ip, err := h.GetIP([]string{"A.com"})
+	if err != nil || ip != "1.1.1.1" {
+		t.Fatalf("GetIP = %q, %v，应只读取区块内的合法 IP", ip, err)
+	}
+	if _, err := h.GetIP([]string{"c.com"}); err == nil {
+		t.Fatal("没有记录时应返回错误")
+	}
+	entries, err := h.Entries([]string{"b.com", "a.com", "c.com"})
+	want := []HostsEntry{{IP: "1.1.1.1", Host: "b.com"}, {IP: "1.1.1.1", Host: "a.com"}}
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Fatalf("Entries = %v, %v", entries, err)
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"CloudflareSpeedTest/config"
 	"context"
 	"sync"
 )
@@ -23,15 +24,41 @@ func (w *Worker) run(ctx context.Context) {
 			if !ok { // 通道关闭时退出
 				return
 			}
-			if task != nil { // 防御 nil task
-				task()
+			if ctx.Err() != nil { // 已取消时丢弃缓冲区中剩余的任务
+				return
+			}
+			if task == nil { // 防御 nil task
+				continue
+			}
+			if err := w.workerPool.acquire(ctx); err != nil {
+				return
 			}
+			task()
+			w.workerPool.release()
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// WorkerPoolOptions 限速与自适应并发配置，零值表示不限速、固定并发
+type WorkerPoolOptions struct {
+	RateLimit        int     // 每秒最多开始的探测（连接或请求）数，任务通过 ProbeContext 的 ctx 调用 WaitProbe，0 不限制
+	Adaptive         bool    // 根据超时比例自动调整并发数
+	MinWorkers       int     // 自适应模式下的最小并发数
+	HighTimeoutRatio float64 // 超时比例高于此值时减半并发
+	LowTimeoutRatio  float64 // 超时比例低于此值时逐步增加并发
+}
+
+// 自适应并发的统计窗口
+type adaptiveState struct {
+	minWorkers       int
+	highTimeoutRatio float64
+	lowTimeoutRatio  float64
+	timeouts         int
+	total            int
+}
+
 type WorkerPool struct {
 	workersNum        int
 	workers           []*Worker
@@ -41,16 +68,55 @@ type WorkerPool struct {
 	cancel            context.CancelFunc
 	cancelOnce        sync.Once
 	wg                sync.WaitGroup
+	limiter           *RateLimiter
+	mu                sync.Mutex
+	cond              *sync.Cond
+	limit             int // 当前允许同时执行的任务数
+	active            int
+	adaptive          *adaptiveState
 }
 
 func NewWorkerPool(workersNum int) *WorkerPool {
-	ctx, cancel := context.WithCancel(context.Background())
+	return NewWorkerPoolWithContext(context.Background(), workersNum)
+}
+
+// NewWorkerPoolWithContext parent 被取消后，worker 不再领取新任务，Submit 立即返回错误
+func NewWorkerPoolWithContext(parent context.Context, workersNum int) *WorkerPool {
+	return NewWorkerPoolWithOptions(parent, workersNum, WorkerPoolOptions{})
+}
+
+func NewWorkerPoolWithOptions(parent context.Context, workersNum int, options WorkerPoolOptions) *WorkerPool {
+	ctx, cancel := context.WithCancel(parent)
 	pool := &WorkerPool{
 		workersNum: workersNum,
 		workers:    make([]*Worker, workersNum),
 		tasksChan:  make(chan func(), workersNum*2),
 		ctx:        ctx,
 		cancel:     cancel,
+		limiter:    NewRateLimiter(options.RateLimit),
+		limit:      workersNum,
+	}
+	pool.cond = sync.NewCond(&pool.mu)
+	if options.Adaptive {
+		minWorkers := options.MinWorkers
+		if minWorkers <= 0 {
+			minWorkers = 1
+		}
+		if minWorkers > workersNum {
+			minWorkers = workersNum
+		}
+		pool.adaptive = &adaptiveState{
+			minWorkers:       minWorkers,
+			highTimeoutRatio: options.HighTimeoutRatio,
+			lowTimeoutRatio:  options.LowTimeoutRatio,
+		}
+		// 取消时唤醒等待并发名额的 worker
+		go func() {
+			<-ctx.Done()
+			pool.mu.Lock()
+			pool.cond.Broadcast()
+			pool.mu.Unlock()
+		}()
 	}
 	pool.wg.Add(workersNum)
 	for i := 0; i < workersNum; i++ {
@@ -60,8 +126,99 @@ func NewWorkerPool(workersNum int) *WorkerPool {
 	return pool
 }
 
-func (pool *WorkerPool) Submit(task func()) {
-	pool.tasksChan <- task
+func (pool *WorkerPool) Context() context.Context {
+	return pool.ctx
+}
+
+// Limit 当前的并发数
+func (pool *WorkerPool) Limit() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.limit
+}
+
+// ProbeContext 带有本池限速器的 ctx，任务中每次探测前调用 WaitProbe(ctx)
+func (pool *WorkerPool) ProbeContext(ctx context.Context) context.Context {
+	return WithRateLimiter(ctx, pool.limiter)
+}
+
+// 等待并发名额
+func (pool *WorkerPool) acquire(ctx context.Context) error {
+	pool.mu.Lock()
+	for pool.active >= pool.limit && ctx.Err() == nil {
+		pool.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		pool.mu.Unlock()
+		return err
+	}
+	pool.active++
+	pool.mu.Unlock()
+	return nil
+}
+
+func (pool *WorkerPool) release() {
+	pool.mu.Lock()
+	pool.active--
+	pool.cond.Signal()
+	pool.mu.Unlock()
+}
+
+// Report 汇报一个任务中超时的探测次数（不含连接被拒绝等错误）和探测总数，自适应模式下据此调整并发数
+func (pool *WorkerPool) Report(timeouts int, total int) {
+	if pool.adaptive == nil || total <= 0 {
+		return
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	a := pool.adaptive
+	a.timeouts += timeouts
+	a.total += total
+	window := pool.limit * 2 // 每个窗口至少覆盖两轮并发，避免抖动
+	if window < 20 {
+		window = 20
+	}
+	if a.total < window {
+		return
+	}
+	ratio := float64(a.timeouts) / float64(a.total)
+	a.timeouts, a.total = 0, 0
+	limit := pool.limit
+	if ratio > a.highTimeoutRatio {
+		limit = pool.limit / 2 // 超时激增，快速退让
+		if limit < a.minWorkers {
+			limit = a.minWorkers
+		}
+	} else if ratio < a.lowTimeoutRatio {
+		step := pool.limit / 4
+		if step < 1 {
+			step = 1
+		}
+		limit = pool.limit + step
+		if limit > pool.workersNum {
+			limit = pool.workersNum
+		}
+	}
+	if limit == pool.limit {
+		return
+	}
+	if config.Debug { // 调试模式下，输出更多信息
+		Yellow.Printf("[调试] 超时比例 %.2f, 并发数 %d -> %d\n", ratio, pool.limit, limit)
+	}
+	pool.limit = limit
+	pool.cond.Broadcast()
+}
+
+func (pool *WorkerPool) Submit(task func()) error {
+	if err := pool.ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case pool.tasksChan <- task:
+		return nil
+	case <-pool.ctx.Done(): // worker 已退出，避免阻塞在满的通道上
+		return pool.ctx.Err()
+	}
 }
 
 func (pool *WorkerPool) Wait() {
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolReport(t *testing.T) {
+	newPool := func() *WorkerPool {
+		pool := NewWorkerPoolWithOptions(context.Background(), 16, WorkerPoolOptions{
+			Adaptive:         true,
+			MinWorkers:       3,
+			HighTimeoutRatio: 0.5,
+			LowTimeoutRatio:  0.1,
+		})
+		t.Cleanup(pool.Stop)
+		return pool
+	}
+
+	t.Run("窗口未满时不调整", func(t *testing.T) {
+		pool := newPool()
+		pool.Report(31, 31) // 16 个并发时窗口为 32 次探测
+		if got := pool.Limit(); got != 16 {
+			t.Fatalf("并发数 = %d，应为 16", got)
+		}
+	})
+
+	t.Run("超时比例高时减半，不低于最小并发", func(t *testing.T) {
+		pool := newPool()
+		want := []int{8, 4, 3, 3}
+		for i, w := range want {
+			for j := 0; j < 8; j++ {
+				pool.Report(4, 4) // 每个任务 4 次探测全部超时
+			}
+			if got := pool.Limit(); got != w {
+				t.Fatalf("第 %d 个窗口后并发数 = %d，应为 %d", i+1, got, w)
+			}
+		}
+	})
+
+	t.Run("超时比例低时逐步增加，不超过 worker 数", func(t *testing.T) {
+		pool := newPool()
+		pool.Report(32, 32)
+		pool.Report(32, 32)
+		if got := pool.Limit(); got != 4 {
+			t.Fatalf("并发数 = %d，应为 4", got)
+		}
+		want := []int{5, 6, 7, 8, 10, 12, 15, 16, 16}
+		for i, w := range want {
+			pool.Report(0, 32)
+			if got := pool.Limit(); got != w {
+				t.Fatalf("第 %d 个窗口后并发数 = %d，应为 %d", i+1, got, w)
+			}
+		}
+	})
+
+	t.Run("比例在两个阈值之间时不变", func(t *testing.T) {
+		pool := newPool()
+		pool.Report(10, 32)
+		if got := pool.Limit(); got != 16 {
+			t.Fatalf("并发数 = %d，应为 16", got)
+		}
+	})
+
+	t.Run("非自适应模式忽略汇报", func(t *testing.T) {
+		pool := NewWorkerPoolWithOptions(context.Background(), 16, WorkerPoolOptions{})
+		defer pool.Stop()
+		pool.Report(100, 100)
+		if got := pool.Limit(); got != 16 {
+			t.Fatalf("并发数 = %d，应为 16", got)
+		}
+	})
+}
+
+func TestWorkerPoolLimitsConcurrency(t *testing.T) {
+	pool := NewWorkerPoolWithOptions(context.Background(), 8, WorkerPoolOptions{
+		Adaptive:         true,
+		MinWorkers:       2,
+		HighTimeoutRatio: 0.5,
+		LowTimeoutRatio:  0.1,
+	})
+	pool.Report(20, 20)
+	pool.Report(20, 20)
+	if got := pool.Limit(); got != 2 {
+		t.Fatalf("并发数 = %d，应为 2", got)
+	}
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+	for i := 0; i < 6; i++ {
+		if err := pool.Submit(func() {
+			mu.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Wait()
+	if peak > 2 {
+		t.Fatalf("同时执行的任务数 = %d，不应超过 2", peak)
+	}
+}